@@ -0,0 +1,44 @@
+package gobot_sim
+
+// Action is executed by the simulator, i.e. when a key is pressed.
+// Implement it to map custom behavior to keys, like writing to
+// multiple pins or driving a virtual device.
+type Action interface {
+	// Pins returns the pins the action operates on, so
+	// the simulator can intercept them
+	Pins() []string
+	// Execute executes the action
+	Execute() error
+}
+
+var (
+	_ Action = (*PinWriteAction)(nil)
+	_ Action = (*PinSequenceAction)(nil)
+	_ Action = (*RotaryEncoderAction)(nil)
+	_ Action = (*FuncAction)(nil)
+
+	_ ContextAction = (*PinWriteAction)(nil)
+	_ ContextAction = (*PinSequenceAction)(nil)
+	_ ContextAction = (*RotaryEncoderAction)(nil)
+)
+
+// FuncAction is an action that calls a function
+type FuncAction struct {
+	pins []string
+	f    func() error
+}
+
+// NewFuncAction creates an action that calls f, operating on the given pins
+func NewFuncAction(f func() error, pins ...string) *FuncAction {
+	return &FuncAction{pins: pins, f: f}
+}
+
+// Pins returns the pins the action operates on
+func (ac *FuncAction) Pins() []string {
+	return ac.pins
+}
+
+// Execute calls the function of the action
+func (ac *FuncAction) Execute() error {
+	return ac.f()
+}
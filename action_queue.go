@@ -0,0 +1,199 @@
+package gobot_sim
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ContextAction is implemented by actions that take time and
+// can be cancelled, i.e. a button press or a waveform
+type ContextAction interface {
+	Action
+	ExecuteContext(ctx context.Context) error
+}
+
+// Releaser is implemented by actions that keep driving a pin after they
+// return, i.e. a held button. A stopped ActionQueue releases them
+type Releaser interface {
+	Release()
+}
+
+// ActionErrorFunc is called when an action executed by an ActionQueue fails
+type ActionErrorFunc func(action Action, err error)
+
+// ErrQueueFull is returned when an action is enqueued on a lane that is full
+var ErrQueueFull = errors.New("action queue is full")
+
+// ErrQueueStopped is returned when an action is enqueued on a stopped queue
+var ErrQueueStopped = errors.New("action queue is stopped")
+
+// ActionQueue executes actions asynchronously. Actions are executed in lanes,
+// one lane for each set of pins. Actions in the same lane (i.e. presses on the same
+// pin) are executed in order. Actions in different lanes run concurrently, unless
+// they share a pin: an action locks all its pins while it executes.
+// Stopping the queue cancels the actions that are executing.
+type ActionQueue struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	lanes   map[string]chan Action
+	size    int
+	onError ActionErrorFunc
+	// releasers are the executed actions that may still drive a pin
+	releasers map[Releaser]bool
+	pinLocks  map[string]*sync.Mutex
+	mutex     *sync.Mutex
+	wg        *sync.WaitGroup
+}
+
+// NewActionQueue creates a queue in which each lane holds up to size pending actions
+func NewActionQueue(size int) *ActionQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ActionQueue{
+		ctx:       ctx,
+		cancel:    cancel,
+		lanes:     make(map[string]chan Action),
+		size:      size,
+		releasers: make(map[Releaser]bool),
+		pinLocks:  make(map[string]*sync.Mutex),
+		mutex:     &sync.Mutex{},
+		wg:        &sync.WaitGroup{},
+	}
+}
+
+// SetErrorFunc sets the function that is called when an action fails
+func (q *ActionQueue) SetErrorFunc(f ActionErrorFunc) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.onError = f
+}
+
+// Enqueue schedules an action for execution in the lane of its pins
+func (q *ActionQueue) Enqueue(action Action) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.ctx.Err() != nil {
+		return ErrQueueStopped
+	}
+	key := laneKey(action.Pins())
+	lane, found := q.lanes[key]
+	if !found {
+		lane = make(chan Action, q.size)
+		q.lanes[key] = lane
+		q.wg.Add(1)
+		go q.run(key, lane)
+	}
+	select {
+	case lane <- action:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Stop cancels the executing actions, discards pending actions,
+// waits until all lanes are finished and releases held pins
+func (q *ActionQueue) Stop() {
+	q.mutex.Lock()
+	q.cancel()
+	q.mutex.Unlock()
+
+	q.wg.Wait()
+
+	q.mutex.Lock()
+	releasers := q.releasers
+	q.releasers = make(map[Releaser]bool)
+	q.mutex.Unlock()
+	for releaser := range releasers {
+		releaser.Release()
+	}
+}
+
+// run executes the actions in a lane until it is drained or the queue is stopped.
+// A drained lane is removed, Enqueue creates it again for the next action
+func (q *ActionQueue) run(key string, lane chan Action) {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case action := <-lane:
+			if err := q.execute(action); err != nil && q.ctx.Err() == nil {
+				q.mutex.Lock()
+				onError := q.onError
+				q.mutex.Unlock()
+				if onError != nil {
+					onError(action, err)
+				}
+			}
+		}
+		// actions are only added to the lane while the queue is locked
+		q.mutex.Lock()
+		if len(lane) == 0 {
+			delete(q.lanes, key)
+			q.mutex.Unlock()
+			return
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// execute executes an action with its pins locked,
+// with the context of the queue if it supports one
+func (q *ActionQueue) execute(action Action) error {
+	unlock := q.lockPins(action.Pins())
+	defer unlock()
+	if err := q.ctx.Err(); err != nil {
+		return err
+	}
+	if releaser, ok := action.(Releaser); ok {
+		q.mutex.Lock()
+		q.releasers[releaser] = true
+		q.mutex.Unlock()
+	}
+	if ca, ok := action.(ContextAction); ok {
+		return ca.ExecuteContext(q.ctx)
+	}
+	return action.Execute()
+}
+
+// lockPins locks pins in sorted order, so actions that share pins
+// cannot deadlock, and returns a function that unlocks them
+func (q *ActionQueue) lockPins(pins []string) func() {
+	sorted := append([]string{}, pins...)
+	sort.Strings(sorted)
+	var locks []*sync.Mutex
+	q.mutex.Lock()
+	for i, pin := range sorted {
+		if i > 0 && pin == sorted[i-1] {
+			continue
+		}
+		lock, found := q.pinLocks[pin]
+		if !found {
+			lock = &sync.Mutex{}
+			q.pinLocks[pin] = lock
+		}
+		locks = append(locks, lock)
+	}
+	q.mutex.Unlock()
+
+	for _, lock := range locks {
+		lock.Lock()
+	}
+	return func() {
+		for _, lock := range locks {
+			lock.Unlock()
+		}
+	}
+}
+
+// laneKey returns the key of the lane for a set of pins
+func laneKey(pins []string) string {
+	sorted := append([]string{}, pins...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
@@ -0,0 +1,274 @@
+package gobot_sim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pinRecorder records the writes to pins, for stub PinFuncs
+type pinRecorder struct {
+	writes []string
+	mutex  sync.Mutex
+}
+
+// funcs returns PinFuncs that record the writes
+func (r *pinRecorder) funcs() *PinFuncs {
+	return &PinFuncs{
+		Write: func(pin string, v byte) error {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+
+			r.writes = append(r.writes, fmt.Sprintf("%s=%d", pin, v))
+			return nil
+		},
+		Read: func(pin string) (int, error) {
+			return PIN_OFF, nil
+		},
+	}
+}
+
+// last returns the last write, or "" if there was none
+func (r *pinRecorder) last() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.writes) == 0 {
+		return ""
+	}
+	return r.writes[len(r.writes)-1]
+}
+
+// waitFor waits until a condition is true, or fails the test after a second
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// lanesCount returns the number of lanes of a queue
+func (q *ActionQueue) lanesCount() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.lanes)
+}
+
+func TestActionQueueOrder(t *testing.T) {
+	q := NewActionQueue(16)
+	defer q.Stop()
+
+	var mutex sync.Mutex
+	var order []int
+	for i := 0; i < 5; i++ {
+		i := i
+		err := q.Enqueue(NewFuncAction(func() error {
+			time.Sleep(time.Millisecond)
+			mutex.Lock()
+			defer mutex.Unlock()
+			order = append(order, i)
+			return nil
+		}, "11"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the actions", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(order) == 5
+	})
+	if got := fmt.Sprint(order); got != "[0 1 2 3 4]" {
+		t.Errorf("order %s, want [0 1 2 3 4]", got)
+	}
+	waitFor(t, "the lane to be removed", func() bool { return q.lanesCount() == 0 })
+}
+
+func TestActionQueueSharedPins(t *testing.T) {
+	q := NewActionQueue(16)
+	defer q.Stop()
+
+	// actions on pin 11 and on pins 11 and 13 are in different lanes,
+	// but must not write pin 11 at the same time
+	var mutex sync.Mutex
+	active, overlaps, done := 0, 0, 0
+	action := func() error {
+		mutex.Lock()
+		active++
+		if active > 1 {
+			overlaps++
+		}
+		mutex.Unlock()
+		time.Sleep(time.Millisecond * 2)
+		mutex.Lock()
+		active--
+		done++
+		mutex.Unlock()
+		return nil
+	}
+	for i := 0; i < 5; i++ {
+		if err := q.Enqueue(NewFuncAction(action, "11")); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Enqueue(NewFuncAction(action, "13", "11")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the actions", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return done == 10
+	})
+	if overlaps > 0 {
+		t.Errorf("%d actions on pin 11 ran at the same time", overlaps)
+	}
+}
+
+func TestActionQueueConcurrent(t *testing.T) {
+	q := NewActionQueue(16)
+	defer q.Stop()
+
+	// each action waits for the other, which only ends if they run concurrently
+	started := map[string]chan struct{}{"11": make(chan struct{}), "13": make(chan struct{})}
+	errs := make(chan error, 2)
+	for pin, other := range map[string]string{"11": "13", "13": "11"} {
+		pin, other := pin, other
+		err := q.Enqueue(NewFuncAction(func() error {
+			close(started[pin])
+			select {
+			case <-started[other]:
+				errs <- nil
+			case <-time.After(time.Second):
+				errs <- fmt.Errorf("pin %s did not run while pin %s did", other, pin)
+			}
+			return nil
+		}, pin))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestActionQueueFull(t *testing.T) {
+	q := NewActionQueue(1)
+	blocked := make(chan struct{})
+	block := NewFuncAction(func() error {
+		<-blocked
+		return nil
+	}, "11")
+	if err := q.Enqueue(block); err != nil {
+		t.Fatal(err)
+	}
+	// the first action is executing or pending, the second fills the lane
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = q.Enqueue(block)
+	}
+	if err != ErrQueueFull {
+		t.Errorf("error %v, want ErrQueueFull", err)
+	}
+	close(blocked)
+	q.Stop()
+	if err := q.Enqueue(block); err != ErrQueueStopped {
+		t.Errorf("error %v after Stop, want ErrQueueStopped", err)
+	}
+}
+
+func TestActionQueueStopCancels(t *testing.T) {
+	q := NewActionQueue(16)
+	var errs []error
+	q.SetErrorFunc(func(action Action, err error) {
+		errs = append(errs, err)
+	})
+	pins := &pinRecorder{}
+	long := NewPinSequenceAction("11", PressSequence(time.Second*10), pins.funcs())
+	if err := q.Enqueue(long); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the press", func() bool { return pins.last() == "11=1" })
+
+	stopped := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not cancel the sequence")
+	}
+	if got := pins.last(); got != "11=0" {
+		t.Errorf("last write %s, want 11=0", got)
+	}
+	if len(errs) > 0 {
+		t.Errorf("errors %v, cancellation is not an error", errs)
+	}
+}
+
+func TestActionQueueStopReleases(t *testing.T) {
+	q := NewActionQueue(16)
+	pins := &pinRecorder{}
+	hold := NewPinWriteAction("11", PW_ACTION_HOLD, pins.funcs())
+	hold.SetHoldTimeout(time.Second * 10)
+	if err := q.Enqueue(hold); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the hold", func() bool { return pins.last() == "11=1" })
+
+	q.Stop()
+	if got := pins.last(); got != "11=0" {
+		t.Errorf("last write %s, want 11=0", got)
+	}
+}
+
+var _ ContextAction = (*blockingAction)(nil)
+
+// blockingAction blocks until its context is done
+type blockingAction struct {
+	started chan struct{}
+}
+
+func (ac *blockingAction) Pins() []string {
+	return []string{"11"}
+}
+
+func (ac *blockingAction) Execute() error {
+	return ac.ExecuteContext(context.Background())
+}
+
+func (ac *blockingAction) ExecuteContext(ctx context.Context) error {
+	close(ac.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestActionQueueStopContext(t *testing.T) {
+	q := NewActionQueue(16)
+	ac := &blockingAction{started: make(chan struct{})}
+	if err := q.Enqueue(ac); err != nil {
+		t.Fatal(err)
+	}
+	<-ac.started
+	stopped := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not cancel the context of the action")
+	}
+}
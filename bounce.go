@@ -0,0 +1,92 @@
+package gobot_sim
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bounce models the contact bounce of a mechanical switch.
+// When a bouncing contact changes state, the pin chatters between the
+// previous and the new value for a while before it settles.
+// Bounce uses a seeded random generator so runs are reproducible.
+type Bounce struct {
+	duration    time.Duration
+	transitions int
+
+	mutex *sync.Mutex
+	rand  *rand.Rand
+}
+
+// NewBounce creates a bounce model. duration is the time it takes for the contact
+// to settle, transitions the number of times the contact briefly falls back
+// to its previous state in that period. seed seeds the random generator that
+// determines the timing of the chatter.
+func NewBounce(duration time.Duration, transitions int, seed int64) *Bounce {
+	return &Bounce{
+		duration:    duration,
+		transitions: transitions,
+		mutex:       &sync.Mutex{},
+		rand:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Duration returns the time it takes for the contact to settle
+func (b *Bounce) Duration() time.Duration {
+	return b.duration
+}
+
+// Transitions returns the number of times the contact falls back during a bounce
+func (b *Bounce) Transitions() int {
+	return b.transitions
+}
+
+// Write changes a pin from one value to another, chattering between the values
+// before settling on the new value. It returns after the bounce duration.
+func (b *Bounce) Write(write PinWriteFunc, pin string, from byte, to byte) error {
+	return b.WriteContext(context.Background(), write, pin, from, to)
+}
+
+// WriteContext is like Write, but can be cancelled. When cancelled,
+// the pin is set to the new value immediately.
+func (b *Bounce) WriteContext(ctx context.Context, write PinWriteFunc, pin string, from byte, to byte) error {
+	start := time.Now()
+	if err := write(pin, to); err != nil {
+		return err
+	}
+	for i, offset := range b.offsets() {
+		if err := sleep(ctx, offset-time.Since(start)); err != nil {
+			write(pin, to)
+			return err
+		}
+		v := from
+		if i%2 == 1 {
+			v = to
+		}
+		if err := write(pin, v); err != nil {
+			return err
+		}
+	}
+	return sleep(ctx, b.duration-time.Since(start))
+}
+
+// offsets returns the sorted random moments at which the chatter
+// changes the pin value, relative to the first edge
+func (b *Bounce) offsets() []time.Duration {
+	if b.duration <= 0 || b.transitions <= 0 {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	offsets := make([]time.Duration, b.transitions*2)
+	for i := range offsets {
+		offsets[i] = time.Duration(b.rand.Int63n(int64(b.duration)))
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	return offsets
+}
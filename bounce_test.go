@@ -0,0 +1,81 @@
+package gobot_sim
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBounceSeed(t *testing.T) {
+	a := NewBounce(time.Millisecond*10, 3, 42)
+	b := NewBounce(time.Millisecond*10, 3, 42)
+	other := NewBounce(time.Millisecond*10, 3, 43)
+	for i := 0; i < 3; i++ {
+		offsets := a.offsets()
+		if len(offsets) != 6 {
+			t.Fatalf("%d offsets, want 6", len(offsets))
+		}
+		for j, offset := range offsets {
+			if offset < 0 || offset >= time.Millisecond*10 || (j > 0 && offset < offsets[j-1]) {
+				t.Errorf("offsets %v are not sorted within the duration", offsets)
+				break
+			}
+		}
+		if got, want := fmt.Sprint(b.offsets()), fmt.Sprint(offsets); got != want {
+			t.Errorf("bounce %d with the same seed: offsets %s, want %s", i, got, want)
+		}
+		if got := fmt.Sprint(other.offsets()); got == fmt.Sprint(offsets) {
+			t.Errorf("bounce %d with another seed: same offsets %s", i, got)
+		}
+	}
+}
+
+// recordWrites returns a write func that records the written values
+func recordWrites(values *[]string) PinWriteFunc {
+	return func(pin string, v byte) error {
+		*values = append(*values, fmt.Sprint(v))
+		return nil
+	}
+}
+
+func TestBounceSettles(t *testing.T) {
+	tests := []struct {
+		name        string
+		transitions int
+		want        string
+	}{
+		{"no chatter", 0, "1"},
+		{"chatter", 3, "1 0 1 0 1 0 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var values []string
+			start := time.Now()
+			bounce := NewBounce(time.Millisecond*5, tt.transitions, 1)
+			if err := bounce.Write(recordWrites(&values), "11", PIN_OFF, PIN_ON); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < time.Millisecond*5 {
+				t.Errorf("returned after %s, before the bounce duration", elapsed)
+			}
+			if got := strings.Join(values, " "); got != tt.want {
+				t.Errorf("writes %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBounceCancel(t *testing.T) {
+	var values []string
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bounce := NewBounce(time.Second, 3, 1)
+	if err := bounce.WriteContext(ctx, recordWrites(&values), "11", PIN_OFF, PIN_ON); err != context.Canceled {
+		t.Errorf("error %v, want context.Canceled", err)
+	}
+	if got := values[len(values)-1]; got != "1" {
+		t.Errorf("last write %s, want the new value 1", got)
+	}
+}
@@ -0,0 +1,52 @@
+// +build example
+//
+// Do not build by default.
+
+package main
+
+import (
+	"github.com/24hoursmedia/gobot-sim/raspi_sim"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gobot.io/x/gobot"
+	"gobot.io/x/gobot/drivers/gpio"
+	"gobot.io/x/gobot/platforms/raspi"
+	"os"
+	"time"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	log.Info().Msg("Setting up simulator - wiring from a config file")
+
+	// set up rasberry pi gobot with a button on pin 11 that toggles a led on pin 13
+	r := raspi.NewAdaptor()
+	button := gpio.NewButtonDriver(r, "11", time.Millisecond*20)
+	button.DefaultState = 1
+	led := gpio.NewLedDriver(r, "13")
+	work := func() {
+		button.On(gpio.ButtonPush, func(data interface{}) {
+			led.Toggle()
+		})
+	}
+
+	robot := gobot.NewRobot("configBot",
+		[]gobot.Connection{r},
+		[]gobot.Device{button, led},
+		work,
+	)
+
+	// hook in the simulator, with the keys, watchers and devices from wiring.json
+	config, err := raspi_sim.LoadConfig("examples/wiring.json")
+	if err != nil {
+		log.Fatal().Err(err).Msg("loading config")
+	}
+	sim, err := raspi_sim.NewGobotSimulatorFromConfig(r, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("applying config")
+	}
+	sim.Run()
+
+	// start the 'real' robot
+	robot.Start()
+}
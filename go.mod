@@ -5,8 +5,8 @@ go 1.16
 require (
 	github.com/gobuffalo/uuid v2.0.5+incompatible // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
-	github.com/hashicorp/go-multierror v1.1.1 // indirect
-	github.com/rs/zerolog v1.20.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1
+	github.com/rs/zerolog v1.20.0
 	go.uber.org/multierr v1.6.0 // indirect
 	gobot.io/x/gobot v1.15.0
 )
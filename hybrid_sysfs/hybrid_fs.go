@@ -4,6 +4,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"gobot.io/x/gobot/sysfs"
 	"os"
+	"sync"
 	"syscall"
 )
 
@@ -27,8 +28,20 @@ type HybridFs struct {
 	mockFs        *sysfs.MockFilesystem
 	mockSysCall   sysfs.MockSyscall
 	mockablePaths map[string]bool
+	readHooks     map[string]ReadHookFunc
+	writeHooks    map[string]WriteHookFunc
+	mutex         *sync.RWMutex
+	filesMutex    *sync.Mutex
 }
 
+// ReadHookFunc determines the contents of a mocked file each time it is read.
+// It receives the current contents, i.e. what was last written to the file.
+type ReadHookFunc func(contents string) string
+
+// WriteHookFunc is called synchronously after a mocked file is written,
+// with the contents that were written
+type WriteHookFunc func(contents string)
+
 func NewHybridFs(nativeFs sysfs.Filesystem, mockFs *sysfs.MockFilesystem) *HybridFs {
 	if len(mockFs.Files) > 0 {
 		panic("mockFs cannot contain files and must be empty when injected")
@@ -37,6 +50,10 @@ func NewHybridFs(nativeFs sysfs.Filesystem, mockFs *sysfs.MockFilesystem) *Hybri
 		nativeFs:      nativeFs,
 		mockFs:        mockFs,
 		mockablePaths: make(map[string]bool),
+		readHooks:     make(map[string]ReadHookFunc),
+		writeHooks:    make(map[string]WriteHookFunc),
+		mutex:         &sync.RWMutex{},
+		filesMutex:    &sync.Mutex{},
 	}
 	return fs
 }
@@ -48,14 +65,62 @@ func (hfs *HybridFs) AddMockablePath(name string) {
 	hfs.mockFs.Add(name)
 }
 
+// SetMockContents sets the contents of a file at a mockable path
+func (hfs *HybridFs) SetMockContents(name string, contents string) {
+	if f, found := hfs.mockFs.Files[name]; found {
+		hfs.filesMutex.Lock()
+		defer hfs.filesMutex.Unlock()
+
+		f.Contents = contents
+	}
+}
+
+// SetReadHook sets a function that determines the contents of a mockable path
+// whenever it is read, so reads can depend on other state
+func (hfs *HybridFs) SetReadHook(name string, hook ReadHookFunc) {
+	hfs.mutex.Lock()
+	defer hfs.mutex.Unlock()
+
+	hfs.readHooks[name] = hook
+}
+
+// SetWriteHook sets a function that is called whenever a mockable path is written,
+// so writes can be observed without polling
+func (hfs *HybridFs) SetWriteHook(name string, hook WriteHookFunc) {
+	hfs.mutex.Lock()
+	defer hfs.mutex.Unlock()
+
+	hfs.writeHooks[name] = hook
+}
+
 func (hfs *HybridFs) OpenFile(name string, flag int, perm os.FileMode) (file sysfs.File, err error) {
-	return hfs.selectFs(name).OpenFile(name, flag, perm)
+	file, err = hfs.selectFs(name).OpenFile(name, flag, perm)
+	if mockFile, ok := file.(*sysfs.MockFile); ok && err == nil {
+		return &hookedFile{MockFile: mockFile, name: name, hfs: hfs, lock: hfs.filesMutex}, nil
+	}
+	return file, err
 }
 
 func (hfs *HybridFs) Stat(name string) (os.FileInfo, error) {
 	return hfs.selectFs(name).Stat(name)
 }
 
+// readHook returns the read hook for a path, or nil if there is none
+func (hfs *HybridFs) readHook(name string) ReadHookFunc {
+	hfs.mutex.RLock()
+	defer hfs.mutex.RUnlock()
+
+	return hfs.readHooks[name]
+}
+
+// writeHook returns the write hook for a path, or nil if there is none
+func (hfs *HybridFs) writeHook(name string) WriteHookFunc {
+	hfs.mutex.RLock()
+	defer hfs.mutex.RUnlock()
+
+	return hfs.writeHooks[name]
+}
+
 // selectFs selects the appropriate filesystem based on a path
 func (hfs *HybridFs) selectFs(name string) sysfs.Filesystem {
 	mockable, found := hfs.mockablePaths[name]
@@ -66,3 +131,63 @@ func (hfs *HybridFs) selectFs(name string) sysfs.Filesystem {
 	log.Trace().Str("path", name).Msg("delegate to native fs")
 	return hfs.nativeFs
 }
+
+// hookedFile is a mock file that calls the hooks of the hybrid filesystem.
+// Reads and writes of mock files are serialized, but hooks are called outside
+// the lock so they may access other files.
+type hookedFile struct {
+	*sysfs.MockFile
+	name string
+	hfs  *HybridFs
+	lock *sync.Mutex
+}
+
+// Read reads the contents determined by the read hook of the file, if any.
+// The contents written to the file are left untouched.
+func (f *hookedFile) Read(b []byte) (n int, err error) {
+	hook := f.hfs.readHook(f.name)
+	f.lock.Lock()
+	contents := f.Contents
+	f.lock.Unlock()
+	if hook == nil {
+		return f.read(b, contents)
+	}
+	return f.read(b, hook(contents))
+}
+
+// read reads contents as if they were the contents of the file
+func (f *hookedFile) read(b []byte, contents string) (n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	written := f.Contents
+	defer func() {
+		f.Contents = written
+	}()
+	f.Contents = contents
+	return f.MockFile.Read(b)
+}
+
+// Write writes to the file and calls the write hook of the file, if any
+func (f *hookedFile) Write(b []byte) (n int, err error) {
+	f.lock.Lock()
+	n, err = f.MockFile.Write(b)
+	f.lock.Unlock()
+	if err != nil {
+		return n, err
+	}
+	if hook := f.hfs.writeHook(f.name); hook != nil {
+		hook(string(b))
+	}
+	return n, nil
+}
+
+// WriteString calls hookedFile.Write
+func (f *hookedFile) WriteString(s string) (ret int, err error) {
+	return f.Write([]byte(s))
+}
+
+// ReadAt calls hookedFile.Read
+func (f *hookedFile) ReadAt(b []byte, off int64) (n int, err error) {
+	return f.Read(b)
+}
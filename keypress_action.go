@@ -1,7 +1,10 @@
 package gobot_sim
 
 import (
+	"context"
 	"errors"
+	"github.com/rs/zerolog/log"
+	"sync"
 	"time"
 )
 
@@ -11,8 +14,22 @@ const (
 	PW_ACTION_OFF
 	PW_ACTION_TOGGLE
 	PW_ACTION_BUTTONPRESS
+	PW_ACTION_HOLD
 )
 
+var pwActionNames = map[int]string{
+	PW_ACTION_ON:          "on",
+	PW_ACTION_OFF:         "off",
+	PW_ACTION_TOGGLE:      "toggle",
+	PW_ACTION_BUTTONPRESS: "button press",
+	PW_ACTION_HOLD:        "hold",
+}
+
+// DefaultHoldTimeout is the default time after the last key repeat at which a
+// PW_ACTION_HOLD releases the pin. It must be longer than the delay before a
+// held key starts to auto repeat.
+const DefaultHoldTimeout = time.Millisecond * 600
+
 type PinFuncs struct {
 	Write PinWriteFunc
 	Read  PinReadFunc
@@ -26,6 +43,13 @@ type PinWriteAction struct {
 	offValue byte
 
 	pinFuncs *PinFuncs
+	bounce   *Bounce
+
+	holdTimeout    time.Duration
+	holdTimer      *time.Timer
+	holdGeneration int
+	holdDone       chan struct{}
+	holdMutex      *sync.Mutex
 }
 
 func NewPinWriteAction(pin string, action int, pinFuncs *PinFuncs) *PinWriteAction {
@@ -35,27 +59,79 @@ func NewPinWriteAction(pin string, action int, pinFuncs *PinFuncs) *PinWriteActi
 		pinFuncs: pinFuncs,
 		onValue:  PIN_ON,
 		offValue: PIN_OFF,
+
+		holdTimeout: DefaultHoldTimeout,
+		holdMutex:   &sync.Mutex{},
 	}
 }
 
 // Pin returns the pin number
-func (ac PinWriteAction) Pin() string {
+func (ac *PinWriteAction) Pin() string {
 	return ac.pin
 }
 
+// Pins returns the pins the action writes to
+func (ac *PinWriteAction) Pins() []string {
+	return []string{ac.pin}
+}
+
 // Action returns the action constant (i.e. PWACTION_TOGGLE etc)
-func (ac PinWriteAction) Action() int {
+func (ac *PinWriteAction) Action() int {
 	return ac.action
 }
 
+// String describes the action
+func (ac *PinWriteAction) String() string {
+	if name, found := pwActionNames[ac.action]; found {
+		return name
+	}
+	return "undefined"
+}
+
+// SetOnOffValues sets the values written for the on and off state,
+// i.e. PIN_OFF and PIN_ON for an active low pin
+func (ac *PinWriteAction) SetOnOffValues(on byte, off byte) {
+	ac.onValue = on
+	ac.offValue = off
+}
+
+// SetBounce sets a bounce model that makes the pin chatter on every
+// edge the action writes. Set to nil for clean edges
+func (ac *PinWriteAction) SetBounce(bounce *Bounce) {
+	ac.bounce = bounce
+}
+
+// Bounce returns the bounce model of the action, nil if edges are clean
+func (ac *PinWriteAction) Bounce() *Bounce {
+	return ac.bounce
+}
+
+// SetHoldTimeout sets the time after the last key repeat at which
+// a PW_ACTION_HOLD releases the pin
+func (ac *PinWriteAction) SetHoldTimeout(timeout time.Duration) {
+	ac.holdTimeout = timeout
+}
+
+// HoldTimeout returns the time after the last key repeat at which
+// a PW_ACTION_HOLD releases the pin
+func (ac *PinWriteAction) HoldTimeout() time.Duration {
+	return ac.holdTimeout
+}
+
 // Execute is called by the owner when an acton on
 // a pin must be executed
 func (ac *PinWriteAction) Execute() error {
+	return ac.ExecuteContext(context.Background())
+}
+
+// ExecuteContext executes the action like Execute, but can be cancelled.
+// A cancelled button press releases the pin before returning
+func (ac *PinWriteAction) ExecuteContext(ctx context.Context) error {
 	switch ac.action {
 	case PW_ACTION_ON:
-		return ac.pinFuncs.Write(ac.pin, ac.onValue)
+		return writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, ac.offValue, ac.onValue)
 	case PW_ACTION_OFF:
-		return ac.pinFuncs.Write(ac.pin, ac.offValue)
+		return writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, ac.onValue, ac.offValue)
 	case PW_ACTION_TOGGLE:
 		val, _ := ac.pinFuncs.Read(ac.pin)
 		//if err != nil {
@@ -64,13 +140,126 @@ func (ac *PinWriteAction) Execute() error {
 		var byteNum byte = 0x00
 		byteNum = byte(val)
 		if byteNum == ac.offValue {
-			return ac.pinFuncs.Write(ac.pin, ac.onValue)
+			return writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, ac.offValue, ac.onValue)
 		}
-		return ac.pinFuncs.Write(ac.pin, ac.offValue)
+		return writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, ac.onValue, ac.offValue)
 	case PW_ACTION_BUTTONPRESS:
-		ac.pinFuncs.Write(ac.pin, ac.onValue)
-		time.Sleep(time.Millisecond * 100)
-		return ac.pinFuncs.Write(ac.pin, ac.offValue)
+		err := writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, ac.offValue, ac.onValue)
+		if err == nil {
+			err = sleep(ctx, time.Millisecond*100-bounceDuration(ac.bounce))
+		}
+		if err != nil {
+			ac.pinFuncs.Write(ac.pin, ac.offValue)
+			return err
+		}
+		return writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, ac.onValue, ac.offValue)
+	case PW_ACTION_HOLD:
+		return ac.hold(ctx)
 	}
 	return errors.New("action not handled")
 }
+
+// hold drives the pin to its on value and keeps it there as long as hold
+// is called again within the hold timeout, i.e. while a key auto repeats.
+// The pin is released when the timeout expires or the context is cancelled.
+func (ac *PinWriteAction) hold(ctx context.Context) error {
+	ac.holdMutex.Lock()
+	defer ac.holdMutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// every hold is a new generation, so a timer of an earlier
+	// generation that already fired does not release the pin
+	ac.holdGeneration++
+	generation := ac.holdGeneration
+	held := ac.holdTimer != nil
+	if held {
+		ac.holdTimer.Stop()
+	}
+	ac.holdTimer = time.AfterFunc(ac.holdTimeout, func() {
+		ac.release(generation)
+	})
+	if held {
+		return nil
+	}
+	ac.holdDone = make(chan struct{})
+	go ac.releaseOnCancel(ctx, ac.holdDone)
+	return writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, ac.offValue, ac.onValue)
+}
+
+// release releases a held pin when the hold timer of the last generation expires
+func (ac *PinWriteAction) release(generation int) {
+	ac.holdMutex.Lock()
+	defer ac.holdMutex.Unlock()
+
+	if generation != ac.holdGeneration || ac.holdTimer == nil {
+		return
+	}
+	ac.releaseHeld()
+}
+
+// releaseOnCancel releases a held pin when the context is cancelled before it is released
+func (ac *PinWriteAction) releaseOnCancel(ctx context.Context, done chan struct{}) {
+	select {
+	case <-ctx.Done():
+		ac.Release()
+	case <-done:
+	}
+}
+
+// Release releases the pin if it is held by a PW_ACTION_HOLD
+func (ac *PinWriteAction) Release() {
+	ac.holdMutex.Lock()
+	defer ac.holdMutex.Unlock()
+
+	if ac.holdTimer == nil {
+		return
+	}
+	ac.releaseHeld()
+}
+
+// releaseHeld releases a held pin, the hold mutex must be locked
+func (ac *PinWriteAction) releaseHeld() {
+	ac.holdTimer.Stop()
+	ac.holdTimer = nil
+	close(ac.holdDone)
+	err := writeEdge(context.Background(), ac.pinFuncs, ac.bounce, ac.pin, ac.onValue, ac.offValue)
+	if err != nil {
+		log.Err(err).Str("pin", ac.pin).Msg("release held pin")
+	}
+}
+
+// writeEdge writes a new value to a pin. If a bounce model is given,
+// the pin chatters between the old and the new value before settling
+func writeEdge(ctx context.Context, pinFuncs *PinFuncs, bounce *Bounce, pin string, from byte, to byte) error {
+	if bounce == nil {
+		return pinFuncs.Write(pin, to)
+	}
+	return bounce.WriteContext(ctx, pinFuncs.Write, pin, from, to)
+}
+
+// bounceDuration returns the time a bounce takes, or 0 for clean edges
+func bounceDuration(bounce *Bounce) time.Duration {
+	if bounce == nil {
+		return 0
+	}
+	return bounce.Duration()
+}
+
+// sleep pauses for d, or until the context is cancelled
+// in which case it returns the error of the context
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
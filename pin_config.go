@@ -0,0 +1,49 @@
+package gobot_sim
+
+const (
+	PULL_NONE = iota
+	PULL_UP
+	PULL_DOWN
+)
+
+// PinConfig describes how a pin is wired, so the simulator can
+// model the values the pin has in idle and active state
+type PinConfig struct {
+	// ActiveLow is true if the pin is active (i.e. a button is pressed, a led is on)
+	// when it is low, like a button connecting an input to ground
+	ActiveLow bool
+	// Pull is the pull resistor of the pin, PULL_NONE for a floating pin
+	Pull int
+	// Input is true for a pin the application reads, like a button. An input
+	// without pull resistor floats, outputs are always driven by the application
+	Input bool
+}
+
+// OnValue returns the value of the pin when active
+func (c PinConfig) OnValue() byte {
+	if c.ActiveLow {
+		return PIN_OFF
+	}
+	return PIN_ON
+}
+
+// OffValue returns the value of the pin when inactive.
+// For a pulled pin this is the pull level, even if that equals the
+// on value; like on a real board, such a button has no effect.
+func (c PinConfig) OffValue() byte {
+	switch c.Pull {
+	case PULL_UP:
+		return PIN_ON
+	case PULL_DOWN:
+		return PIN_OFF
+	}
+	if c.ActiveLow {
+		return PIN_ON
+	}
+	return PIN_OFF
+}
+
+// Floating returns true if an unconnected input has no defined value
+func (c PinConfig) Floating() bool {
+	return c.Input && c.Pull != PULL_UP && c.Pull != PULL_DOWN
+}
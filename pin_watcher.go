@@ -1,5 +1,31 @@
 package gobot_sim
 
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// edges that are reported by a PinWatcher
+const (
+	EDGE_BOTH = iota
+	EDGE_RISING
+	EDGE_FALLING
+)
+
+// how the owner detects changes for a PinWatcher
+const (
+	// WATCH_AUTO observes writes to the pin, and polls when that is not enough
+	WATCH_AUTO = iota
+	// WATCH_EVENTS only observes writes to the pin
+	WATCH_EVENTS
+	// WATCH_POLL only polls the pin at the interval of the watcher
+	WATCH_POLL
+)
+
+// eventSeq numbers the events of all watchers, so they can be ordered across pins
+var eventSeq uint64
+
 type WatchFuncs struct {
 	Read    PinReadFunc
 	Changed PinChangedFunc
@@ -13,6 +39,17 @@ type PinWatcher struct {
 	watchFuncs *WatchFuncs
 	previous   int
 	triggering bool
+
+	edges         int
+	debounce      time.Duration
+	lockedUntil   time.Time
+	minPulseWidth time.Duration
+	pending       int
+	pendingSince  time.Time
+	hasPending    bool
+	mode          int
+	interval      time.Duration
+	mutex         *sync.Mutex
 }
 
 // Name returns the name of the watcher and can be used in
@@ -35,35 +72,220 @@ func NewPinWatcher(pin string, watchFuncs *WatchFuncs) *PinWatcher {
 		onValue:    PIN_ON,
 		offValue:   PIN_OFF,
 		watchFuncs: watchFuncs,
+		mutex:      &sync.Mutex{},
 	}
 	return w
 }
 
+// SetOnOffValues sets the values of the pin in on and off state,
+// i.e. PIN_OFF and PIN_ON for an active low led
+func (w *PinWatcher) SetOnOffValues(on byte, off byte) {
+	w.onValue = on
+	w.offValue = off
+}
+
+// SetEdges sets which changes are reported: EDGE_BOTH (the default), EDGE_RISING
+// for changes to a higher value or EDGE_FALLING for changes to a lower value.
+// Edges are electrical, use PinChangedEvent.On for the logical state
+func (w *PinWatcher) SetEdges(edges int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.edges = edges
+}
+
+// Edges returns which changes are reported
+func (w *PinWatcher) Edges() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.edges
+}
+
+// SetDebounce ignores changes for a duration after a reported change.
+// When the duration has passed, the settled value is reported if it differs
+// from the last reported value
+func (w *PinWatcher) SetDebounce(d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.debounce = d
+}
+
+// Debounce returns the debounce duration
+func (w *PinWatcher) Debounce() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.debounce
+}
+
+// SetMinPulseWidth only reports a change after the new value was held for
+// a duration, so shorter pulses and glitches are ignored. The change is reported
+// when the pin is polled or changes again after the duration, with the time it started
+func (w *PinWatcher) SetMinPulseWidth(d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.minPulseWidth = d
+}
+
+// MinPulseWidth returns the minimum pulse width
+func (w *PinWatcher) MinPulseWidth() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.minPulseWidth
+}
+
+// SetMode sets how the owner detects changes: WATCH_AUTO (the default),
+// WATCH_EVENTS or WATCH_POLL
+func (w *PinWatcher) SetMode(mode int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.mode = mode
+}
+
+// Mode returns how the owner detects changes
+func (w *PinWatcher) Mode() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.mode
+}
+
+// SetInterval sets how often the owner polls the watcher,
+// 0 uses the interval of the owner
+func (w *PinWatcher) SetInterval(d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.interval = d
+}
+
+// Interval returns how often the owner polls the watcher, 0 for the interval of the owner
+func (w *PinWatcher) Interval() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.interval
+}
+
 // Pin returns the pin number
 func (w *PinWatcher) Pin() string {
 	return w.pin
 }
 
 // Observe must be called periodically by the owner
-// and detects changes in state.
+// and detects changes in state. It returns the error of the read
+// or of the change handler, if any.
+// The pin is read while the watcher is locked, so a value that is read before
+// a concurrent ObserveValue is never applied after it.
 func (w *PinWatcher) Observe() error {
+	w.mutex.Lock()
 	v, err := w.watchFuncs.Read(w.pin)
 	if err != nil {
+		w.mutex.Unlock()
 		return err
 	}
-	if w.triggering {
-		if v != w.previous && w.watchFuncs.Changed != nil {
-			ev := PinChangedEvent{
-				Pin:       w.pin,
-				LastValue: w.previous,
-				Value:     v,
-				source:    w,
-			}
-			w.watchFuncs.Changed(ev)
-		}
-	} else {
+	ev, changed := w.apply(v, time.Now())
+	w.mutex.Unlock()
+	return w.report(ev, changed)
+}
+
+// ObserveValue detects changes in state from a value of the pin that
+// is already known, i.e. when the owner is notified of a write to the pin.
+// It may be called concurrently with Observe.
+func (w *PinWatcher) ObserveValue(v int) error {
+	return w.report(w.observe(v, time.Now()))
+}
+
+// report calls the change handler with an event, if there is one
+func (w *PinWatcher) report(ev PinChangedEvent, changed bool) error {
+	if changed && w.watchFuncs.Changed != nil {
+		return w.watchFuncs.Changed(ev)
+	}
+	return nil
+}
+
+// observe updates the state with a value and returns the event to report, if any
+func (w *PinWatcher) observe(v int, now time.Time) (PinChangedEvent, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.apply(v, now)
+}
+
+// apply updates the state with a value while the watcher is locked
+func (w *PinWatcher) apply(v int, now time.Time) (PinChangedEvent, bool) {
+	if !w.triggering {
 		w.triggering = true
+		w.previous = v
+		return PinChangedEvent{}, false
+	}
+	if now.Before(w.lockedUntil) {
+		return PinChangedEvent{}, false
 	}
+	if w.minPulseWidth > 0 {
+		return w.observePulse(v, now)
+	}
+	if v == w.previous {
+		return PinChangedEvent{}, false
+	}
+	return w.change(v, now)
+}
+
+// observePulse reports a change once the new value was held for the minimum
+// pulse width. The change is confirmed by a later observation, which may be the
+// next edge, and is reported with the time it started
+func (w *PinWatcher) observePulse(v int, now time.Time) (PinChangedEvent, bool) {
+	if w.hasPending && now.Sub(w.pendingSince) >= w.minPulseWidth {
+		pending, since := w.pending, w.pendingSince
+		w.hasPending = false
+		if v != pending {
+			w.pending, w.pendingSince, w.hasPending = v, now, true
+		}
+		return w.change(pending, since)
+	}
+	if v == w.previous {
+		w.hasPending = false
+		return PinChangedEvent{}, false
+	}
+	if !w.hasPending || w.pending != v {
+		w.pending, w.pendingSince, w.hasPending = v, now, true
+	}
+	return PinChangedEvent{}, false
+}
+
+// change records a change at a time and returns the event to report, if any
+func (w *PinWatcher) change(v int, at time.Time) (PinChangedEvent, bool) {
+	last := w.previous
 	w.previous = v
-	return nil
+	if w.debounce > 0 {
+		w.lockedUntil = at.Add(w.debounce)
+	}
+	if !w.matchesEdge(last, v) {
+		return PinChangedEvent{}, false
+	}
+	return PinChangedEvent{
+		Pin:       w.pin,
+		LastValue: last,
+		Value:     v,
+		On:        v == int(w.onValue),
+		Time:      at,
+		Seq:       atomic.AddUint64(&eventSeq, 1),
+		source:    w,
+	}, true
+}
+
+// matchesEdge returns whether a change is reported
+func (w *PinWatcher) matchesEdge(from int, to int) bool {
+	switch w.edges {
+	case EDGE_RISING:
+		return to > from
+	case EDGE_FALLING:
+		return to < from
+	}
+	return true
 }
@@ -0,0 +1,141 @@
+package gobot_sim
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sample is a value of a pin, observed at a time in ms after the start
+type sample struct {
+	ms int
+	v  int
+}
+
+// observeSamples observes samples with a watcher and returns the reported
+// events as "value@ms", with the time of the event
+func observeSamples(w *PinWatcher, samples []sample) []string {
+	start := time.Now()
+	var events []string
+	for _, s := range samples {
+		if ev, changed := w.observe(s.v, start.Add(time.Duration(s.ms)*time.Millisecond)); changed {
+			events = append(events, fmt.Sprintf("%d@%d", ev.Value, ev.Time.Sub(start).Milliseconds()))
+		}
+	}
+	return events
+}
+
+func TestPinWatcherEdges(t *testing.T) {
+	samples := []sample{{0, 0}, {10, 1}, {20, 0}, {30, 1}, {40, 1}, {50, 0}}
+	tests := []struct {
+		name  string
+		edges int
+		want  string
+	}{
+		{"both", EDGE_BOTH, "[1@10 0@20 1@30 0@50]"},
+		{"rising", EDGE_RISING, "[1@10 1@30]"},
+		{"falling", EDGE_FALLING, "[0@20 0@50]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewPinWatcher("11", &WatchFuncs{})
+			w.SetEdges(tt.edges)
+			if got := fmt.Sprint(observeSamples(w, samples)); got != tt.want {
+				t.Errorf("events %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinWatcherDebounce(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []sample
+		want    string
+	}{
+		{"bounce is ignored", []sample{{0, 0}, {10, 1}, {11, 0}, {12, 1}, {13, 0}, {14, 1}, {40, 1}}, "[1@10]"},
+		{"settled value is reported", []sample{{0, 0}, {10, 1}, {12, 0}, {25, 0}}, "[1@10 0@25]"},
+		{"changes after debounce", []sample{{0, 0}, {10, 1}, {30, 0}, {50, 1}}, "[1@10 0@30 1@50]"},
+		{"no change after bounce", []sample{{0, 0}, {10, 1}, {12, 0}, {14, 1}, {25, 1}}, "[1@10]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewPinWatcher("11", &WatchFuncs{})
+			w.SetDebounce(time.Millisecond * 10)
+			if got := fmt.Sprint(observeSamples(w, tt.samples)); got != tt.want {
+				t.Errorf("events %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinWatcherMinPulseWidth(t *testing.T) {
+	tests := []struct {
+		name    string
+		edges   int
+		samples []sample
+		want    string
+	}{
+		{"glitch is ignored", EDGE_BOTH, []sample{{0, 0}, {10, 1}, {15, 0}, {40, 0}}, "[]"},
+		{"confirmed by a poll", EDGE_BOTH, []sample{{0, 0}, {10, 1}, {15, 1}, {21, 1}}, "[1@10]"},
+		{"confirmed by the next edge", EDGE_BOTH, []sample{{0, 0}, {10, 1}, {25, 0}}, "[1@10]"},
+		{"pulse without polls", EDGE_BOTH, []sample{{0, 0}, {10, 1}, {25, 0}, {50, 1}}, "[1@10 0@25]"},
+		{"pulse with polls", EDGE_BOTH, []sample{{0, 0}, {10, 1}, {22, 1}, {25, 0}, {40, 0}}, "[1@10 0@25]"},
+		{"glitch during a pulse", EDGE_BOTH, []sample{{0, 0}, {10, 1}, {25, 0}, {27, 1}, {45, 0}}, "[1@10]"},
+		{"rising", EDGE_RISING, []sample{{0, 0}, {10, 1}, {25, 0}, {50, 1}, {70, 0}}, "[1@10 1@50]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewPinWatcher("11", &WatchFuncs{})
+			w.SetEdges(tt.edges)
+			w.SetMinPulseWidth(time.Millisecond * 10)
+			if got := fmt.Sprint(observeSamples(w, tt.samples)); got != tt.want {
+				t.Errorf("events %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinWatcherStaleRead(t *testing.T) {
+	// the pin is polled and reads 0, then 1 is written before the read returns
+	reading, release := make(chan struct{}), make(chan struct{})
+	var mutex sync.Mutex
+	var events []int
+	w := NewPinWatcher("11", &WatchFuncs{
+		Read: func(pin string) (int, error) {
+			close(reading)
+			<-release
+			return 0, nil
+		},
+		Changed: func(ev PinChangedEvent) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, ev.Value)
+			return nil
+		},
+	})
+	w.ObserveValue(0)
+
+	polled := make(chan error)
+	go func() {
+		polled <- w.Observe()
+	}()
+	<-reading
+	written := make(chan error)
+	go func() {
+		written <- w.ObserveValue(1)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	close(release)
+	if err := <-polled; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	// the stale 0 must not be applied after the written 1
+	if got := fmt.Sprint(events); got != "[1]" {
+		t.Errorf("events %s, want [1]", got)
+	}
+}
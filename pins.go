@@ -1,5 +1,7 @@
 package gobot_sim
 
+import "time"
+
 const PIN_ON = 0x01
 const PIN_OFF = 0x00
 
@@ -7,7 +9,15 @@ type PinChangedEvent struct {
 	Pin       string
 	LastValue int
 	Value     int
-	source    interface{}
+	// On is true if Value is the on value of the pin, i.e. PIN_OFF for active low
+	On bool
+	// Time is when the change was detected, which is when the pin
+	// was written for pins that are written through the simulator.
+	// It has a monotonic clock reading, so Time.Sub measures durations between events
+	Time time.Time
+	// Seq numbers the events of all watchers in the order they were detected
+	Seq    uint64
+	source interface{}
 }
 
 func (p PinChangedEvent) Source() interface{} {
@@ -0,0 +1,161 @@
+package gobot_sim
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultPulseWindow is the default number of periods a PulseMeter averages over
+const DefaultPulseWindow = 16
+
+// PulseStats are the rolling statistics of a pulsing pin, over the last periods.
+// High and low are electrical, so for an active low led the led is on for 1 - DutyCycle.
+type PulseStats struct {
+	Pin string
+	// Periods is the number of periods the statistics are calculated from
+	Periods int
+	// Frequency is the average frequency in Hz
+	Frequency float64
+	// DutyCycle is the average fraction of a period the pin is high
+	DutyCycle float64
+	// Period, HighTime and LowTime are averages
+	Period    time.Duration
+	HighTime  time.Duration
+	LowTime   time.Duration
+	MinPeriod time.Duration
+	MaxPeriod time.Duration
+	// Time is the time of the edge that completed the last period
+	Time time.Time
+}
+
+// PulseStatsFunc is called by a PulseMeter for each completed period
+type PulseStatsFunc func(stats PulseStats) error
+
+// pulse is a single measured period
+type pulse struct {
+	high time.Duration
+	low  time.Duration
+}
+
+// PulseMeter measures the frequency, duty cycle and pulse durations of a pin
+// from its changes, i.e. of a software PWM or blinking led.
+// A period runs from a rising edge to the next rising edge.
+type PulseMeter struct {
+	pin      string
+	window   int
+	pulses   []pulse
+	lastRise time.Time
+	lastFall time.Time
+	handler  PulseStatsFunc
+	mutex    *sync.Mutex
+}
+
+// NewPulseMeter creates a meter for a pin, that calls handler
+// (if not nil) with the statistics after each period
+func NewPulseMeter(pin string, handler PulseStatsFunc) *PulseMeter {
+	return &PulseMeter{
+		pin:     pin,
+		window:  DefaultPulseWindow,
+		handler: handler,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// Pin returns the pin number
+func (m *PulseMeter) Pin() string {
+	return m.pin
+}
+
+// SetWindow sets the number of periods the statistics are calculated from
+func (m *PulseMeter) SetWindow(periods int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if periods < 1 {
+		periods = 1
+	}
+	m.window = periods
+	if len(m.pulses) > periods {
+		m.pulses = m.pulses[len(m.pulses)-periods:]
+	}
+}
+
+// Reset forgets all measured periods
+func (m *PulseMeter) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.pulses = nil
+	m.lastRise = time.Time{}
+	m.lastFall = time.Time{}
+}
+
+// Changed measures a change of the pin and can be used as PinChangedFunc of a watcher
+func (m *PulseMeter) Changed(ev PinChangedEvent) error {
+	stats, completed := m.measure(ev)
+	if completed && m.handler != nil {
+		return m.handler(stats)
+	}
+	return nil
+}
+
+// Stats returns the statistics of the last periods
+func (m *PulseMeter) Stats() PulseStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.stats()
+}
+
+// measure records an edge and returns the statistics if it completed a period
+func (m *PulseMeter) measure(ev PinChangedEvent) (PulseStats, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if ev.Value < ev.LastValue {
+		m.lastFall = ev.Time
+		return PulseStats{}, false
+	}
+	rise := m.lastRise
+	m.lastRise = ev.Time
+	if rise.IsZero() || m.lastFall.Before(rise) {
+		return PulseStats{}, false
+	}
+	m.pulses = append(m.pulses, pulse{
+		high: m.lastFall.Sub(rise),
+		low:  ev.Time.Sub(m.lastFall),
+	})
+	if len(m.pulses) > m.window {
+		m.pulses = m.pulses[1:]
+	}
+	return m.stats(), true
+}
+
+// stats calculates the statistics of the recorded periods
+func (m *PulseMeter) stats() PulseStats {
+	stats := PulseStats{Pin: m.pin, Periods: len(m.pulses), Time: m.lastRise}
+	if len(m.pulses) == 0 {
+		return stats
+	}
+	var high, low time.Duration
+	for i, p := range m.pulses {
+		period := p.high + p.low
+		high += p.high
+		low += p.low
+		if i == 0 || period < stats.MinPeriod {
+			stats.MinPeriod = period
+		}
+		if period > stats.MaxPeriod {
+			stats.MaxPeriod = period
+		}
+	}
+	n := time.Duration(len(m.pulses))
+	stats.HighTime = high / n
+	stats.LowTime = low / n
+	stats.Period = (high + low) / n
+	if stats.Period > 0 {
+		stats.Frequency = float64(time.Second) / float64(stats.Period)
+		stats.DutyCycle = float64(high) / float64(high+low)
+	}
+	return stats
+}
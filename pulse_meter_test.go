@@ -0,0 +1,87 @@
+package gobot_sim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// feedWaveform feeds a meter the edges of periods of a square wave that is high
+// for high and low for low, starting with a rising edge at start. It returns
+// the time after the last period.
+func feedWaveform(t *testing.T, m *PulseMeter, start time.Time, high time.Duration, low time.Duration, periods int) time.Time {
+	at := start
+	for i := 0; i < periods; i++ {
+		for _, ev := range []PinChangedEvent{
+			{Pin: m.Pin(), LastValue: PIN_OFF, Value: PIN_ON, Time: at},
+			{Pin: m.Pin(), LastValue: PIN_ON, Value: PIN_OFF, Time: at.Add(high)},
+		} {
+			if err := m.Changed(ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+		at = at.Add(high + low)
+	}
+	return at
+}
+
+func TestPulseMeter(t *testing.T) {
+	tests := []struct {
+		name      string
+		high      time.Duration
+		low       time.Duration
+		frequency float64
+		dutyCycle float64
+	}{
+		{"50 Hz at 25%", time.Millisecond * 5, time.Millisecond * 15, 50, 0.25},
+		{"1 kHz at 50%", time.Microsecond * 500, time.Microsecond * 500, 1000, 0.5},
+		{"2 Hz at 90%", time.Millisecond * 450, time.Millisecond * 50, 2, 0.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completed := 0
+			m := NewPulseMeter("11", func(stats PulseStats) error {
+				completed++
+				return nil
+			})
+			// the last rising edge completes the tenth period
+			end := feedWaveform(t, m, time.Now(), tt.high, tt.low, 11)
+			stats := m.Stats()
+			if completed != 10 || stats.Periods != 10 {
+				t.Errorf("%d periods reported, %d in the stats, want 10", completed, stats.Periods)
+			}
+			if math.Abs(stats.Frequency-tt.frequency) > 1e-9 {
+				t.Errorf("frequency %g, want %g", stats.Frequency, tt.frequency)
+			}
+			if math.Abs(stats.DutyCycle-tt.dutyCycle) > 1e-9 {
+				t.Errorf("duty cycle %g, want %g", stats.DutyCycle, tt.dutyCycle)
+			}
+			if stats.HighTime != tt.high || stats.LowTime != tt.low {
+				t.Errorf("high %s low %s, want %s and %s", stats.HighTime, stats.LowTime, tt.high, tt.low)
+			}
+			if want := end.Add(-tt.high - tt.low); !stats.Time.Equal(want) {
+				t.Errorf("time %s, want the last rising edge %s", stats.Time, want)
+			}
+		})
+	}
+}
+
+func TestPulseMeterWindow(t *testing.T) {
+	m := NewPulseMeter("11", nil)
+	m.SetWindow(4)
+	// 100 Hz, then 50 Hz for longer than the window
+	at := feedWaveform(t, m, time.Now(), time.Millisecond*5, time.Millisecond*5, 8)
+	feedWaveform(t, m, at, time.Millisecond*10, time.Millisecond*10, 6)
+
+	stats := m.Stats()
+	if stats.Periods != 4 {
+		t.Errorf("%d periods, want the window of 4", stats.Periods)
+	}
+	if stats.MinPeriod != time.Millisecond*20 || stats.MaxPeriod != time.Millisecond*20 {
+		t.Errorf("periods from %s to %s, want only the 20ms periods", stats.MinPeriod, stats.MaxPeriod)
+	}
+	m.Reset()
+	if stats := m.Stats(); stats.Periods != 0 || stats.Frequency != 0 {
+		t.Errorf("stats %+v after Reset", stats)
+	}
+}
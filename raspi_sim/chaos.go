@@ -0,0 +1,181 @@
+package raspi_sim
+
+import (
+	"context"
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/rs/zerolog/log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosEvent is an event generated by a ChaosGenerator
+type ChaosEvent struct {
+	// Seq is the sequence number of the event, starting at 1
+	Seq int
+	// At is the time of the event, relative to the start of the generator
+	At     time.Duration
+	Pin    string
+	Action string
+}
+
+// chaosRule generates events for a pin at a Poisson rate
+type chaosRule struct {
+	pin    string
+	action int
+	rate   float64
+	next   time.Duration
+}
+
+// ChaosGenerator soak tests a robot by randomly pressing, toggling and holding
+// input pins. Events for each pin occur at a Poisson rate. The generator is seeded,
+// so the same seed generates the same events at the same times, and every event is
+// logged so a failure can be reproduced.
+type ChaosGenerator struct {
+	seed         int64
+	rand         *rand.Rand
+	rules        []*chaosRule
+	holdDuration time.Duration
+	events       []ChaosEvent
+	mutex        *sync.Mutex
+	cancel       context.CancelFunc
+
+	sim *GobotSimulator
+}
+
+// EnableChaos creates a chaos generator with a seed,
+// that starts generating events when the simulator runs
+func (sim *GobotSimulator) EnableChaos(seed int64) *ChaosGenerator {
+	sim.chaos = &ChaosGenerator{
+		seed:         seed,
+		rand:         rand.New(rand.NewSource(seed)),
+		holdDuration: time.Second,
+		mutex:        &sync.Mutex{},
+		sim:          sim,
+	}
+	return sim.chaos
+}
+
+// AddPin generates events for a pin, on average rate events per second.
+// action is PW_ACTION_BUTTONPRESS, PW_ACTION_TOGGLE or PW_ACTION_HOLD,
+// which holds the pin for the hold duration.
+func (c *ChaosGenerator) AddPin(pin string, action int, rate float64) error {
+	switch action {
+	case gobot_sim.PW_ACTION_BUTTONPRESS, gobot_sim.PW_ACTION_TOGGLE, gobot_sim.PW_ACTION_HOLD:
+	default:
+		return fmt.Errorf("action %d is not supported by the chaos generator", action)
+	}
+	if rate <= 0 {
+		return fmt.Errorf("invalid rate %f", rate)
+	}
+	if err := c.sim.usePinForGPIO(pin); err != nil {
+		return err
+	}
+	c.rules = append(c.rules, &chaosRule{pin: pin, action: action, rate: rate})
+	return nil
+}
+
+// SetHoldDuration sets how long PW_ACTION_HOLD events hold a pin (defaults to 1s)
+func (c *ChaosGenerator) SetHoldDuration(d time.Duration) {
+	c.holdDuration = d
+}
+
+// Seed returns the seed of the generator
+func (c *ChaosGenerator) Seed() int64 {
+	return c.seed
+}
+
+// Events returns the events generated so far
+func (c *ChaosGenerator) Events() []ChaosEvent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]ChaosEvent{}, c.events...)
+}
+
+// Stop stops generating events
+func (c *ChaosGenerator) Stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// start generates events until the generator is stopped
+func (c *ChaosGenerator) start() {
+	if len(c.rules) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mutex.Lock()
+	c.cancel = cancel
+	c.mutex.Unlock()
+
+	log.Info().Int64("seed", c.seed).Int("pins", len(c.rules)).Msg("chaos - starting")
+	for _, rule := range c.rules {
+		rule.next = c.interval(rule.rate)
+	}
+	go c.run(ctx, time.Now())
+}
+
+// run fires the events of all rules in order of time
+func (c *ChaosGenerator) run(ctx context.Context, start time.Time) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for seq := 1; ; seq++ {
+		rule := c.rules[0]
+		for _, r := range c.rules[1:] {
+			if r.next < rule.next {
+				rule = r
+			}
+		}
+		timer.Reset(rule.next - time.Since(start))
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		c.fire(seq, rule)
+		rule.next += c.interval(rule.rate)
+	}
+}
+
+// fire executes the action of a rule
+func (c *ChaosGenerator) fire(seq int, rule *chaosRule) {
+	config := c.sim.pinConfig(rule.pin)
+	var ac gobot_sim.Action
+	var name string
+	switch rule.action {
+	case gobot_sim.PW_ACTION_HOLD:
+		hold := gobot_sim.NewPinSequenceAction(rule.pin, gobot_sim.PressSequence(c.holdDuration), c.sim.PinFuncs())
+		hold.SetOnOffValues(config.OnValue(), config.OffValue())
+		ac, name = hold, "hold"
+	default:
+		write := gobot_sim.NewPinWriteAction(rule.pin, rule.action, c.sim.PinFuncs())
+		write.SetOnOffValues(config.OnValue(), config.OffValue())
+		ac, name = write, write.String()
+	}
+
+	ev := ChaosEvent{Seq: seq, At: rule.next, Pin: rule.pin, Action: name}
+	c.mutex.Lock()
+	c.events = append(c.events, ev)
+	c.mutex.Unlock()
+	log.Info().Int64("seed", c.seed).Int("seq", ev.Seq).Dur("at", ev.At).
+		Str("pin", ev.Pin).Str("action", ev.Action).Msg("chaos - event")
+
+	if err := c.sim.actionQueue.Enqueue(ac); err != nil {
+		log.Err(err).Int("seq", ev.Seq).Str("pin", ev.Pin).Msg("chaos - event")
+	}
+}
+
+// interval draws the time until the next event of a Poisson process
+func (c *ChaosGenerator) interval(rate float64) time.Duration {
+	seconds := -math.Log(1-c.rand.Float64()) / rate
+	return time.Duration(seconds * float64(time.Second))
+}
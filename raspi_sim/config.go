@@ -0,0 +1,423 @@
+package raspi_sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Config describes the simulated wiring of a robot: the pin map,
+// pin wiring, key bindings, watchers and virtual devices.
+// It is usually loaded from a JSON file with LoadConfig.
+type Config struct {
+	Name      string                     `json:"name"`
+	PinMap    *PinMapConfig              `json:"pin_map"`
+	NoiseSeed *int64                     `json:"noise_seed"`
+	Pins      map[string]PinWiringConfig `json:"pins"`
+	Keys      []KeyConfig                `json:"keys"`
+	Watchers  []WatcherConfig            `json:"watchers"`
+	Devices   DevicesConfig              `json:"devices"`
+}
+
+// PinMapConfig maps board pins to gpio numbers, for boards other than the RPI3
+type PinMapConfig struct {
+	Revision string            `json:"revision"`
+	Mapping  map[string]string `json:"mapping"`
+}
+
+// PinWiringConfig describes how a pin is wired, see gobot_sim.PinConfig
+type PinWiringConfig struct {
+	ActiveLow bool `json:"active_low"`
+	// Pull is "up", "down" or "none"
+	Pull string `json:"pull"`
+	// Input is true for a pin the application reads, an input without pull floats
+	Input bool `json:"input"`
+}
+
+// KeyConfig maps a key to an action on a pin
+type KeyConfig struct {
+	// Key is a single character, or one of "up", "down", "left", "right" and "space"
+	Key string `json:"key"`
+	Pin string `json:"pin"`
+	// Action is one of "on", "off", "toggle", "press", "hold" and "sequence"
+	Action string `json:"action"`
+	// Pattern is the waveform of a "sequence" action, see gobot_sim.ParseSequencePattern
+	Pattern     string        `json:"pattern"`
+	HoldTimeout Duration      `json:"hold_timeout"`
+	Bounce      *BounceConfig `json:"bounce"`
+}
+
+// BounceConfig configures contact bounce, see gobot_sim.NewBounce
+type BounceConfig struct {
+	Duration    Duration `json:"duration"`
+	Transitions int      `json:"transitions"`
+	Seed        int64    `json:"seed"`
+}
+
+// WatcherConfig logs changes of a pin
+type WatcherConfig struct {
+	Name string `json:"name"`
+	Pin  string `json:"pin"`
+	// Log is a text/template for the log message, with the fields of
+	// gobot_sim.PinChangedEvent and the Name of the watcher
+	Log string `json:"log"`
+	// Edge is "rising", "falling" or "both"
+	Edge          string   `json:"edge"`
+	Debounce      Duration `json:"debounce"`
+	MinPulseWidth Duration `json:"min_pulse_width"`
+	// Mode is "auto", "events" or "poll", see gobot_sim.PinWatcher.SetMode
+	Mode     string   `json:"mode"`
+	Interval Duration `json:"interval"`
+}
+
+// DevicesConfig lists the virtual devices
+type DevicesConfig struct {
+	RotaryEncoders []RotaryEncoderConfig `json:"rotary_encoders"`
+	Keypads        []KeypadConfig        `json:"keypads"`
+}
+
+// RotaryEncoderConfig configures a rotary encoder, see GobotSimulator.AddRotaryEncoder
+type RotaryEncoderConfig struct {
+	PinA      string `json:"pin_a"`
+	PinB      string `json:"pin_b"`
+	CWKey     string `json:"cw_key"`
+	CCWKey    string `json:"ccw_key"`
+	SwitchPin string `json:"switch_pin"`
+	SwitchKey string `json:"switch_key"`
+}
+
+// KeypadConfig configures a matrix keypad, see GobotSimulator.AddMatrixKeypad
+type KeypadConfig struct {
+	Rows []string `json:"rows"`
+	Cols []string `json:"cols"`
+	// Layout has a string of keys for each row, defaults to a 4x4 layout
+	Layout []string `json:"layout"`
+	// Keys maps additional keyboard keys to keypad keys, i.e. {"/": "#"}
+	Keys       map[string]string `json:"keys"`
+	ActiveHigh bool              `json:"active_high"`
+}
+
+// Duration is a time.Duration that is written as a string like "100ms" in a config file
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig loads a wiring configuration from a JSON file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses a wiring configuration in JSON format.
+// Unknown fields, i.e. misspelled options, and options that do not
+// apply to an action are rejected
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the configuration")
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// validate checks that the options of the keys and devices apply to them
+func (config *Config) validate() error {
+	for _, keyConfig := range config.Keys {
+		if err := keyConfig.validate(); err != nil {
+			return fmt.Errorf("key '%s': %w", keyConfig.Key, err)
+		}
+	}
+	for _, encoderConfig := range config.Devices.RotaryEncoders {
+		if encoderConfig.SwitchKey != "" && encoderConfig.SwitchPin == "" {
+			return fmt.Errorf("rotary encoder on pins %s, %s: switch_key without switch_pin", encoderConfig.PinA, encoderConfig.PinB)
+		}
+	}
+	return nil
+}
+
+// keyActions are the actions of keys that write a pin, by name
+var keyActions = map[string]int{
+	"on":     gobot_sim.PW_ACTION_ON,
+	"off":    gobot_sim.PW_ACTION_OFF,
+	"toggle": gobot_sim.PW_ACTION_TOGGLE,
+	"press":  gobot_sim.PW_ACTION_BUTTONPRESS,
+	"hold":   gobot_sim.PW_ACTION_HOLD,
+}
+
+// validate checks that the action of a key exists and its options apply to it
+func (c KeyConfig) validate() error {
+	if _, found := keyActions[c.Action]; !found && c.Action != "sequence" {
+		return fmt.Errorf("invalid action '%s'", c.Action)
+	}
+	if c.Pattern != "" && c.Action != "sequence" {
+		return fmt.Errorf("pattern does not apply to action '%s'", c.Action)
+	}
+	if c.HoldTimeout != 0 && c.Action != "hold" {
+		return fmt.Errorf("hold_timeout does not apply to action '%s'", c.Action)
+	}
+	return nil
+}
+
+// NewGobotSimulatorFromConfig creates a simulator with the wiring of a configuration
+func NewGobotSimulatorFromConfig(adapter RaspiAdaptor, config *Config) (*GobotSimulator, error) {
+	sim := NewGobotSimulator(adapter)
+	if err := sim.ApplyConfig(config); err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+// ApplyConfig sets up the wiring of a configuration
+func (sim *GobotSimulator) ApplyConfig(config *Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.Name != "" {
+		sim.name = config.Name
+	}
+	if config.PinMap != nil {
+		sim.SetPinToGPIOMap(NewPinToGPIOMap(config.PinMap.Revision, config.PinMap.Mapping))
+	}
+	if config.NoiseSeed != nil {
+		sim.SetNoiseSeed(*config.NoiseSeed)
+	}
+	for pin, wiring := range config.Pins {
+		pinConfig, err := wiring.pinConfig()
+		if err != nil {
+			return fmt.Errorf("pin %s: %w", pin, err)
+		}
+		if err := sim.ConfigurePin(pin, pinConfig); err != nil {
+			return fmt.Errorf("pin %s: %w", pin, err)
+		}
+	}
+	for _, keyConfig := range config.Keys {
+		if err := sim.applyKeyConfig(keyConfig); err != nil {
+			return fmt.Errorf("key '%s': %w", keyConfig.Key, err)
+		}
+	}
+	for _, watcherConfig := range config.Watchers {
+		if err := sim.applyWatcherConfig(watcherConfig); err != nil {
+			return fmt.Errorf("watcher for pin %s: %w", watcherConfig.Pin, err)
+		}
+	}
+	for _, encoderConfig := range config.Devices.RotaryEncoders {
+		if err := sim.applyRotaryEncoderConfig(encoderConfig); err != nil {
+			return fmt.Errorf("rotary encoder on pins %s, %s: %w", encoderConfig.PinA, encoderConfig.PinB, err)
+		}
+	}
+	for _, keypadConfig := range config.Devices.Keypads {
+		if err := sim.applyKeypadConfig(keypadConfig); err != nil {
+			return fmt.Errorf("keypad: %w", err)
+		}
+	}
+	return nil
+}
+
+// pinConfig translates the wiring to a pin configuration
+func (c PinWiringConfig) pinConfig() (gobot_sim.PinConfig, error) {
+	pinConfig := gobot_sim.PinConfig{ActiveLow: c.ActiveLow, Input: c.Input}
+	switch c.Pull {
+	case "up":
+		pinConfig.Pull = gobot_sim.PULL_UP
+	case "down":
+		pinConfig.Pull = gobot_sim.PULL_DOWN
+	case "", "none":
+		pinConfig.Pull = gobot_sim.PULL_NONE
+	default:
+		return pinConfig, fmt.Errorf("invalid pull '%s'", c.Pull)
+	}
+	return pinConfig, nil
+}
+
+// applyKeyConfig maps a key to an action
+func (sim *GobotSimulator) applyKeyConfig(c KeyConfig) error {
+	key, err := parseKey(c.Key)
+	if err != nil {
+		return err
+	}
+	var bounce *gobot_sim.Bounce
+	if c.Bounce != nil {
+		bounce = gobot_sim.NewBounce(time.Duration(c.Bounce.Duration), c.Bounce.Transitions, c.Bounce.Seed)
+	}
+
+	if c.Action == "sequence" {
+		ac, err := sim.AddKeyPressPatternAction(key, c.Pin, c.Pattern)
+		if err != nil {
+			return err
+		}
+		ac.SetBounce(bounce)
+		return nil
+	}
+
+	ac, err := sim.AddKeyPressPWAction(key, c.Pin, keyActions[c.Action])
+	if err != nil {
+		return err
+	}
+	ac.SetBounce(bounce)
+	if c.HoldTimeout > 0 {
+		ac.SetHoldTimeout(time.Duration(c.HoldTimeout))
+	}
+	return nil
+}
+
+// watcherLogData is passed to the log template of a watcher
+type watcherLogData struct {
+	gobot_sim.PinChangedEvent
+	Name string
+}
+
+// applyWatcherConfig adds a watcher that logs pin changes
+func (sim *GobotSimulator) applyWatcherConfig(c WatcherConfig) error {
+	text := c.Log
+	if text == "" {
+		text = "pin {{.Pin}} changed from {{.LastValue}} to {{.Value}}"
+	}
+	tmpl, err := template.New(c.Name).Parse(text)
+	if err != nil {
+		return err
+	}
+	watcher, err := sim.WatchPin(c.Pin, func(ev gobot_sim.PinChangedEvent) error {
+		var msg bytes.Buffer
+		if err := tmpl.Execute(&msg, watcherLogData{PinChangedEvent: ev, Name: c.Name}); err != nil {
+			return err
+		}
+		log.Info().Str("watcher", c.Name).Str("pin", ev.Pin).Int("pinVal", ev.Value).Msg(msg.String())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	watcher.SetName(c.Name)
+	watcher.SetDebounce(time.Duration(c.Debounce))
+	watcher.SetMinPulseWidth(time.Duration(c.MinPulseWidth))
+	switch c.Edge {
+	case "rising":
+		watcher.SetEdges(gobot_sim.EDGE_RISING)
+	case "falling":
+		watcher.SetEdges(gobot_sim.EDGE_FALLING)
+	case "", "both":
+	default:
+		sim.UnwatchPin(watcher)
+		return fmt.Errorf("invalid edge '%s'", c.Edge)
+	}
+	watcher.SetInterval(time.Duration(c.Interval))
+	switch c.Mode {
+	case "events":
+		watcher.SetMode(gobot_sim.WATCH_EVENTS)
+	case "poll":
+		watcher.SetMode(gobot_sim.WATCH_POLL)
+	case "", "auto":
+	default:
+		sim.UnwatchPin(watcher)
+		return fmt.Errorf("invalid mode '%s'", c.Mode)
+	}
+	return nil
+}
+
+// applyRotaryEncoderConfig adds a rotary encoder
+func (sim *GobotSimulator) applyRotaryEncoderConfig(c RotaryEncoderConfig) error {
+	cwKey, err := parseKey(c.CWKey)
+	if err != nil {
+		return err
+	}
+	ccwKey, err := parseKey(c.CCWKey)
+	if err != nil {
+		return err
+	}
+	enc, err := sim.AddRotaryEncoder(cwKey, ccwKey, c.PinA, c.PinB)
+	if err != nil {
+		return err
+	}
+	if c.SwitchPin == "" {
+		return nil
+	}
+	switchKey, err := parseKey(c.SwitchKey)
+	if err != nil {
+		return err
+	}
+	return sim.AddRotaryEncoderSwitch(switchKey, enc, c.SwitchPin)
+}
+
+// applyKeypadConfig adds a matrix keypad
+func (sim *GobotSimulator) applyKeypadConfig(c KeypadConfig) error {
+	layout := KeypadLayout4x4
+	if len(c.Layout) > 0 {
+		layout = make([][]rune, len(c.Layout))
+		for i, row := range c.Layout {
+			layout[i] = []rune(row)
+		}
+	}
+	kp, err := sim.AddMatrixKeypad(c.Rows, c.Cols, layout)
+	if err != nil {
+		return err
+	}
+	if c.ActiveHigh {
+		kp.SetScanValue(gobot_sim.PIN_ON)
+	}
+	for pcKey, keypadKey := range c.Keys {
+		key, err := parseKey(pcKey)
+		if err != nil {
+			return err
+		}
+		if utf8.RuneCountInString(keypadKey) != 1 {
+			return fmt.Errorf("invalid keypad key '%s'", keypadKey)
+		}
+		if err := sim.AddKeypadKey(key, kp, []rune(keypadKey)[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseKey parses a key name from a config file
+func parseKey(s string) (rune, error) {
+	names := map[string]rune{
+		"up":    KeyArrowUp,
+		"down":  KeyArrowDown,
+		"left":  KeyArrowLeft,
+		"right": KeyArrowRight,
+		"space": ' ',
+	}
+	if key, found := names[strings.ToLower(s)]; found {
+		return key, nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("invalid key '%s'", s)
+	}
+	return []rune(s)[0], nil
+}
@@ -0,0 +1,83 @@
+package raspi_sim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadExampleConfig(t *testing.T) {
+	config, err := LoadConfig("../examples/wiring.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "configured sim" || len(config.Keys) != 3 || len(config.Watchers) != 1 {
+		t.Errorf("config %+v", config)
+	}
+	if got := time.Duration(config.Keys[2].HoldTimeout); got != time.Millisecond*800 {
+		t.Errorf("hold timeout %s, want 800ms", got)
+	}
+
+	sim := newTestSimulator()
+	if err := sim.ApplyConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []rune{'1', '2', 'h', KeyArrowRight, KeyArrowLeft, ' '} {
+		if _, found := sim.gpioKeymap[key]; !found {
+			t.Errorf("key %q is not mapped", key)
+		}
+	}
+	if len(sim.watchers()) != 1 {
+		t.Errorf("%d watchers, want 1", len(sim.watchers()))
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"invalid json", `{"name": "sim"`},
+		{"data after config", `{"name": "sim"} {}`},
+		{"unknown field", `{"nmae": "sim"}`},
+		{"misspelled key option", `{"keys": [{"key": "h", "pin": "11", "action": "hold", "hold_timout": "1s"}]}`},
+		{"misspelled watcher option", `{"watchers": [{"pin": "13", "debounse": "10ms"}]}`},
+		{"misspelled bounce option", `{"keys": [{"key": "1", "pin": "11", "action": "press", "bounce": {"duraton": "5ms"}}]}`},
+		{"invalid duration", `{"keys": [{"key": "h", "pin": "11", "action": "hold", "hold_timeout": "long"}]}`},
+		{"invalid action", `{"keys": [{"key": "1", "pin": "11", "action": "presss"}]}`},
+		{"pattern on a press", `{"keys": [{"key": "1", "pin": "11", "action": "press", "pattern": "1:1s 0"}]}`},
+		{"hold timeout on a toggle", `{"keys": [{"key": "1", "pin": "11", "action": "toggle", "hold_timeout": "1s"}]}`},
+		{"switch key without pin", `{"devices": {"rotary_encoders": [{"pin_a": "15", "pin_b": "16", "cw_key": "right", "ccw_key": "left", "switch_key": "space"}]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseConfig([]byte(tt.config)); err == nil {
+				t.Error("parsed, want an error")
+			}
+		})
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"invalid pull", `{"pins": {"11": {"pull": "sideways"}}}`},
+		{"invalid pin", `{"pins": {"99": {"pull": "up"}}}`},
+		{"invalid key", `{"keys": [{"key": "ab", "pin": "11", "action": "press"}]}`},
+		{"invalid pattern", `{"keys": [{"key": "1", "pin": "11", "action": "sequence", "pattern": "1:100ms x2"}]}`},
+		{"invalid edge", `{"watchers": [{"pin": "13", "edge": "up"}]}`},
+		{"invalid mode", `{"watchers": [{"pin": "13", "mode": "sometimes"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseConfig([]byte(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := newTestSimulator().ApplyConfig(config); err == nil {
+				t.Error("applied, want an error")
+			}
+		})
+	}
+}
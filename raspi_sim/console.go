@@ -0,0 +1,234 @@
+package raspi_sim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"gobot.io/x/gobot/platforms/keyboard"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const consoleHelp = `commands:
+  press PIN [DURATION]  press and release a button, i.e. press 11 2s
+  set PIN VALUE         write 0 or 1 to a pin
+  toggle PIN            toggle a pin
+  read PIN              read the value of a pin
+  watch PIN             print changes of a pin
+  unwatch PIN           stop printing changes of a pin
+  pins                  list all pins with their gpio numbers and values
+  help                  show this help`
+
+// EnableConsole enables the command console, which accepts commands like
+// "press 11" or "set 13 1" to drive any pin of the pin map without binding keys.
+// Pressing key opens a command prompt, Enter executes the command and Escape
+// closes the prompt. With key 0 there is no prompt; use ServeConsole
+// to read commands from another source.
+// All pins of the pin map are intercepted, so the console must be enabled before Run.
+func (sim *GobotSimulator) EnableConsole(key rune) error {
+	for pin := range sim.pinToGPIOMap.mapping {
+		if err := sim.usePinForGPIO(pin); err != nil {
+			return err
+		}
+	}
+	sim.consoleKey = key
+	sim.consoleWatchers = make(map[string]*gobot_sim.PinWatcher)
+	sim.consoleMutex = &sync.Mutex{}
+	return nil
+}
+
+// ServeConsole executes commands read line by line from r and writes their
+// output to w, until r is exhausted. The console must be enabled.
+func (sim *GobotSimulator) ServeConsole(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		sim.execAndPrint(scanner.Text(), w)
+	}
+	return scanner.Err()
+}
+
+// ExecCommand executes a single console command and returns its output
+func (sim *GobotSimulator) ExecCommand(line string) (string, error) {
+	if sim.consoleWatchers == nil {
+		return "", errors.New("console is not enabled")
+	}
+	sim.consoleMutex.Lock()
+	defer sim.consoleMutex.Unlock()
+
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return "", nil
+	}
+	command, args := strings.ToLower(args[0]), args[1:]
+
+	switch command {
+	case "help":
+		return consoleHelp, nil
+	case "pins":
+		return sim.consolePins(), nil
+	case "press", "set", "toggle", "read", "watch", "unwatch":
+	default:
+		return "", fmt.Errorf("unknown command '%s', type help for a list of commands", command)
+	}
+
+	if len(args) < 1 {
+		return "", fmt.Errorf("%s: missing pin", command)
+	}
+	pin := args[0]
+	if err := sim.consolePin(pin); err != nil {
+		return "", err
+	}
+	config := sim.pinConfig(pin)
+
+	switch command {
+	case "press":
+		var ac gobot_sim.Action
+		if len(args) > 1 {
+			d, err := time.ParseDuration(args[1])
+			if err != nil {
+				return "", fmt.Errorf("press: invalid duration '%s'", args[1])
+			}
+			sequence := gobot_sim.NewPinSequenceAction(pin, gobot_sim.PressSequence(d), sim.PinFuncs())
+			sequence.SetOnOffValues(config.OnValue(), config.OffValue())
+			ac = sequence
+		} else {
+			press := gobot_sim.NewPinWriteAction(pin, gobot_sim.PW_ACTION_BUTTONPRESS, sim.PinFuncs())
+			press.SetOnOffValues(config.OnValue(), config.OffValue())
+			ac = press
+		}
+		return "", sim.actionQueue.Enqueue(ac)
+	case "set":
+		if len(args) < 2 {
+			return "", errors.New("set: missing value")
+		}
+		v, err := strconv.Atoi(args[1])
+		if err != nil || (v != gobot_sim.PIN_ON && v != gobot_sim.PIN_OFF) {
+			return "", fmt.Errorf("set: invalid value '%s'", args[1])
+		}
+		return "", sim.pinWrite(pin, byte(v))
+	case "toggle":
+		toggle := gobot_sim.NewPinWriteAction(pin, gobot_sim.PW_ACTION_TOGGLE, sim.PinFuncs())
+		toggle.SetOnOffValues(config.OnValue(), config.OffValue())
+		return "", sim.actionQueue.Enqueue(toggle)
+	case "read":
+		v, err := sim.pinRead(pin)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: %d", pin, v), nil
+	case "watch":
+		return "", sim.consoleWatch(pin)
+	case "unwatch":
+		w, found := sim.consoleWatchers[pin]
+		if !found {
+			return "", fmt.Errorf("unwatch: pin %s is not watched", pin)
+		}
+		delete(sim.consoleWatchers, pin)
+		sim.UnwatchPin(w)
+	}
+	return "", nil
+}
+
+// execAndPrint executes a command and prints the output or error
+func (sim *GobotSimulator) execAndPrint(line string, w io.Writer) {
+	out, err := sim.ExecCommand(line)
+	if err != nil {
+		fmt.Fprintf(w, "error: %s\n", err)
+		return
+	}
+	if out != "" {
+		fmt.Fprintln(w, out)
+	}
+}
+
+// consolePin checks whether a pin can be used in the console
+func (sim *GobotSimulator) consolePin(pin string) error {
+	gpio, err := sim.pinToGPIOMap.ToGPIO(pin)
+	if err != nil {
+		return fmt.Errorf("pin %s: %w", pin, err)
+	}
+	if !sim.intercepted(gpio) {
+		return fmt.Errorf("pin %s is not simulated", pin)
+	}
+	return nil
+}
+
+// consoleWatch prints the changes of a pin to the output
+func (sim *GobotSimulator) consoleWatch(pin string) error {
+	if _, found := sim.consoleWatchers[pin]; found {
+		return nil
+	}
+	w, err := sim.WatchPin(pin, func(ev gobot_sim.PinChangedEvent) error {
+		_, err := fmt.Fprintf(sim.output, "pin %s: %d -> %d\n", ev.Pin, ev.LastValue, ev.Value)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.SetName("console")
+	sim.consoleWatchers[pin] = w
+	return nil
+}
+
+// consolePins lists the pins of the pin map with their gpio numbers and values
+func (sim *GobotSimulator) consolePins() string {
+	pins := make([]string, 0, len(sim.pinToGPIOMap.mapping))
+	for pin := range sim.pinToGPIOMap.mapping {
+		pins = append(pins, pin)
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		a, _ := strconv.Atoi(pins[i])
+		b, _ := strconv.Atoi(pins[j])
+		return a < b
+	})
+	gpios := sim.gpioNumbers(pins)
+	values := sim.pinValues(pins)
+	lines := make([]string, len(pins))
+	for i, pin := range pins {
+		lines[i] = fmt.Sprintf("pin %s (gpio %s): %s", pin, gpios[i], values[i])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// consoleInput handles a key press while the console prompt is open
+func (sim *GobotSimulator) consoleInput(ev keyboard.KeyEvent) {
+	if ev.Bytes[1] != 0 || ev.Bytes[2] != 0 {
+		// escape sequences, like arrow keys
+		return
+	}
+	key := rune(ev.Bytes[0])
+	switch key {
+	case '\n', '\r':
+		line := string(sim.consoleLine)
+		sim.consoleLine = nil
+		sim.consoleActive = false
+		fmt.Fprintln(sim.output)
+		sim.execAndPrint(line, sim.output)
+	case 27:
+		sim.consoleLine = nil
+		sim.consoleActive = false
+		fmt.Fprintln(sim.output)
+	case 127, '\b':
+		if len(sim.consoleLine) > 0 {
+			sim.consoleLine = sim.consoleLine[:len(sim.consoleLine)-1]
+			fmt.Fprint(sim.output, "\b \b")
+		}
+	default:
+		if key >= ' ' && key < 127 {
+			sim.consoleLine = append(sim.consoleLine, key)
+			fmt.Fprint(sim.output, string(key))
+		}
+	}
+}
+
+// openConsolePrompt opens the command prompt
+func (sim *GobotSimulator) openConsolePrompt() {
+	sim.consoleActive = true
+	sim.consoleLine = nil
+	fmt.Fprint(sim.output, "> ")
+}
@@ -0,0 +1,156 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+)
+
+// what the simulator does when a watcher or action fails.
+// Errors are always counted per pin.
+const (
+	ERRORS_LOG = iota
+	ERRORS_COUNT
+	ERRORS_STOP
+	ERRORS_FAIL
+)
+
+// TestReporter reports errors to a test, it is implemented by *testing.T
+type TestReporter interface {
+	Errorf(format string, args ...interface{})
+}
+
+// SimulatorError is an error of a watcher or action
+type SimulatorError struct {
+	// Source is "watcher", "action" or the device that failed, i.e. "signal generator"
+	Source string
+	Pins   []string
+	Err    error
+}
+
+// Error describes the error
+func (e *SimulatorError) Error() string {
+	return fmt.Sprintf("%s on pin %s: %s", e.Source, strings.Join(e.Pins, ","), e.Err)
+}
+
+// Unwrap returns the original error
+func (e *SimulatorError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandlerFunc handles errors of watchers and actions
+type ErrorHandlerFunc func(err *SimulatorError)
+
+// errorPolicy decides what happens with errors and counts them
+type errorPolicy struct {
+	policy   int
+	reporter TestReporter
+	handler  ErrorHandlerFunc
+	counts   map[string]int
+	stopping bool
+	mutex    *sync.Mutex
+}
+
+// SetErrorPolicy sets what happens when a watcher or action fails: ERRORS_LOG
+// (the default) logs the error, ERRORS_COUNT only counts it and ERRORS_STOP
+// logs the error and stops the simulator. ERRORS_FAIL needs a test, set it with
+// FailOnError; without a test errors are logged
+func (sim *GobotSimulator) SetErrorPolicy(policy int) {
+	sim.errors.mutex.Lock()
+	defer sim.errors.mutex.Unlock()
+
+	sim.errors.policy = policy
+}
+
+// FailOnError reports every error of a watcher or action to a test,
+// i.e. sim.FailOnError(t) fails the test
+func (sim *GobotSimulator) FailOnError(t TestReporter) {
+	sim.errors.mutex.Lock()
+	defer sim.errors.mutex.Unlock()
+
+	sim.errors.policy = ERRORS_FAIL
+	sim.errors.reporter = t
+}
+
+// SetErrorHandler sets a function that handles errors of watchers and actions
+// instead of the error policy. Set to nil to use the error policy again
+func (sim *GobotSimulator) SetErrorHandler(handler ErrorHandlerFunc) {
+	sim.errors.mutex.Lock()
+	defer sim.errors.mutex.Unlock()
+
+	sim.errors.handler = handler
+}
+
+// ErrorCount returns the number of errors of watchers and actions for a pin
+func (sim *GobotSimulator) ErrorCount(pin string) int {
+	sim.errors.mutex.Lock()
+	defer sim.errors.mutex.Unlock()
+
+	return sim.errors.counts[pin]
+}
+
+// ErrorCounts returns the number of errors of watchers and actions per pin
+func (sim *GobotSimulator) ErrorCounts() map[string]int {
+	sim.errors.mutex.Lock()
+	defer sim.errors.mutex.Unlock()
+
+	counts := make(map[string]int, len(sim.errors.counts))
+	for pin, count := range sim.errors.counts {
+		counts[pin] = count
+	}
+	return counts
+}
+
+// handleError counts an error and handles it according to the error policy
+func (sim *GobotSimulator) handleError(source string, pins []string, err error) {
+	simErr := &SimulatorError{Source: source, Pins: pins, Err: err}
+
+	sim.errors.mutex.Lock()
+	for _, pin := range pins {
+		sim.errors.counts[pin]++
+	}
+	policy, reporter, handler := sim.errors.policy, sim.errors.reporter, sim.errors.handler
+	stop := handler == nil && policy == ERRORS_STOP && !sim.errors.stopping
+	if stop {
+		sim.errors.stopping = true
+	}
+	sim.errors.mutex.Unlock()
+
+	if handler != nil {
+		handler(simErr)
+		return
+	}
+	switch policy {
+	case ERRORS_COUNT:
+	case ERRORS_FAIL:
+		if reporter != nil {
+			reporter.Errorf("gobot-sim: %s", simErr)
+			break
+		}
+		// without a test the error is logged
+		fallthrough
+	default:
+		log.Err(err).Str("source", source).Strs("pins", pins).Msg("Simulator error")
+	}
+	if stop {
+		log.Warn().Msg("Stopping simulator after error")
+		// errors can occur while executing actions, which Stop waits for
+		go sim.Stop()
+	}
+}
+
+// observe observes a watcher and handles its errors
+func (sim *GobotSimulator) observe(w *gobot_sim.PinWatcher) {
+	if err := w.Observe(); err != nil {
+		sim.handleError("watcher", []string{w.Pin()}, err)
+	}
+}
+
+// observeValue observes a written value with a watcher and handles its errors
+func (sim *GobotSimulator) observeValue(w *gobot_sim.PinWatcher, v int) {
+	if err := w.ObserveValue(v); err != nil {
+		sim.handleError("watcher", []string{w.Pin()}, err)
+	}
+}
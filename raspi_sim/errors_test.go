@@ -0,0 +1,115 @@
+package raspi_sim
+
+import (
+	"errors"
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"testing"
+)
+
+// stubReporter records the errors reported to a test
+type stubReporter struct {
+	errors []string
+}
+
+// Errorf records an error
+func (r *stubReporter) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// newTestSimulator creates a simulator that is not run
+func newTestSimulator() *GobotSimulator {
+	return NewGobotSimulator(NewVAdaptor(RPI3PinGPIOMap))
+}
+
+func TestErrorPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   int
+		reporter bool
+		handler  bool
+		reported int
+		handled  int
+		stopping bool
+	}{
+		{"log", ERRORS_LOG, false, false, 0, 0, false},
+		{"count", ERRORS_COUNT, false, false, 0, 0, false},
+		{"stop", ERRORS_STOP, false, false, 0, 0, true},
+		{"fail", ERRORS_FAIL, true, false, 1, 0, false},
+		{"fail without test", ERRORS_FAIL, false, false, 0, 0, false},
+		{"handler", ERRORS_STOP, true, true, 0, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newTestSimulator()
+			reporter := &stubReporter{}
+			if tt.reporter {
+				sim.FailOnError(reporter)
+			}
+			sim.SetErrorPolicy(tt.policy)
+			var handled []*SimulatorError
+			if tt.handler {
+				sim.SetErrorHandler(func(err *SimulatorError) {
+					handled = append(handled, err)
+				})
+			}
+
+			sim.handleError("action", []string{"11", "13"}, errors.New("broken"))
+
+			if got := sim.ErrorCount("11"); got != 1 {
+				t.Errorf("%d errors on pin 11, want 1", got)
+			}
+			if got := sim.ErrorCounts(); got["13"] != 1 || len(got) != 2 {
+				t.Errorf("error counts %v, want 1 for pins 11 and 13", got)
+			}
+			if len(reporter.errors) != tt.reported {
+				t.Errorf("reported %q, want %d errors", reporter.errors, tt.reported)
+			}
+			if len(handled) != tt.handled {
+				t.Errorf("handled %v, want %d errors", handled, tt.handled)
+			}
+			if len(handled) > 0 && handled[0].Error() != "action on pin 11,13: broken" {
+				t.Errorf("error %q", handled[0].Error())
+			}
+			sim.errors.mutex.Lock()
+			stopping := sim.errors.stopping
+			sim.errors.mutex.Unlock()
+			if stopping != tt.stopping {
+				t.Errorf("stopping %v, want %v", stopping, tt.stopping)
+			}
+		})
+	}
+}
+
+func TestDeviceErrors(t *testing.T) {
+	failing := &gobot_sim.PinFuncs{
+		Write: func(pin string, v byte) error { return errors.New("write failed") },
+		Read:  func(pin string) (int, error) { return 0, errors.New("read failed") },
+	}
+	sim := newTestSimulator()
+	var sources []string
+	sim.SetErrorHandler(func(err *SimulatorError) {
+		sources = append(sources, err.Source)
+	})
+
+	gen, err := sim.AddSquareWave("11", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.pinFuncs = failing
+	gen.write(gobot_sim.PIN_ON)
+
+	sensor, err := sim.AddHCSR04("13", "15", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sensor.pinFuncs = failing
+	sensor.write(gobot_sim.PIN_ON)
+
+	if fmt.Sprint(sources) != "[signal generator hc-sr04]" {
+		t.Errorf("errors of %v, want the signal generator and the hc-sr04", sources)
+	}
+	if sim.ErrorCount("11") != 1 || sim.ErrorCount("15") != 1 {
+		t.Errorf("error counts %v, want 1 for pins 11 and 15", sim.ErrorCounts())
+	}
+}
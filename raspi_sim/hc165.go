@@ -0,0 +1,222 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/24hoursmedia/gobot-sim/hybrid_sysfs"
+	"strconv"
+	"sync"
+)
+
+// HC165 is a virtual chain of 74HC165 parallel in, serial out shift registers.
+// The inputs are loaded while the load (SH/LD) pin is low, and shifted out on the
+// serial (QH) pin on each rising edge of the clock (CLK) pin. The inputs are
+// virtual pins that can be written like real pins, i.e. by key press actions.
+type HC165 struct {
+	loadPin   string
+	clockPin  string
+	serialPin string
+
+	inputs   []int
+	names    map[string]int
+	onValue  byte
+	offValue byte
+	loading  bool
+	shift    []int
+	mutex    *sync.Mutex
+}
+
+// NewHC165 creates a chain of chips 74HC165s. The serial pin is connected to QH of
+// the first chip and SER of each chip to QH of the next, so the inputs are numbered
+// D0-D7 (A-H) on the first chip, D8-D15 on the second chip etc, and are shifted out
+// starting with D7 of the first chip
+func NewHC165(loadPin string, clockPin string, serialPin string, chips int) (*HC165, error) {
+	if chips < 1 {
+		return nil, fmt.Errorf("a 74HC165 chain needs at least one chip, not %d", chips)
+	}
+	r := &HC165{
+		loadPin:   loadPin,
+		clockPin:  clockPin,
+		serialPin: serialPin,
+		inputs:    make([]int, chips*8),
+		names:     make(map[string]int),
+		onValue:   gobot_sim.PIN_ON,
+		offValue:  gobot_sim.PIN_OFF,
+		shift:     make([]int, chips*8),
+		mutex:     &sync.Mutex{},
+	}
+	for input := range r.inputs {
+		r.names[r.InputPin(input)] = input
+	}
+	return r, nil
+}
+
+// Pins returns the load, clock and serial pins
+func (r *HC165) Pins() []string {
+	return []string{r.loadPin, r.clockPin, r.serialPin}
+}
+
+// InputPin returns the name of the virtual pin of an input, i.e. "165@11:D3"
+// for D3 of a chain with the load on pin 11
+func (r *HC165) InputPin(input int) string {
+	return fmt.Sprintf("165@%s:D%d", r.loadPin, input)
+}
+
+// SetOnOffValues sets the values of the inputs in on and off state, i.e. PIN_OFF
+// and PIN_ON for buttons with pull up resistors. All inputs are set to off
+func (r *HC165) SetOnOffValues(on byte, off byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.onValue = on
+	r.offValue = off
+	for input := range r.inputs {
+		r.inputs[input] = int(off)
+	}
+}
+
+// OnOffValues returns the values of the inputs in on and off state
+func (r *HC165) OnOffValues() (byte, byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.onValue, r.offValue
+}
+
+// SetInput sets the value of an input
+func (r *HC165) SetInput(input int, v byte) error {
+	if input < 0 || input >= len(r.inputs) {
+		return fmt.Errorf("input D%d does not exist, the chain has %d inputs", input, len(r.inputs))
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.inputs[input] = int(v)
+	return nil
+}
+
+// Input returns the value of an input
+func (r *HC165) Input(input int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.inputs[input]
+}
+
+// Inputs returns the values of all inputs, starting with D0 of the first chip
+func (r *HC165) Inputs() []int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]int{}, r.inputs...)
+}
+
+// PinFuncs returns the functions to read and write the virtual input pins,
+// for use in actions
+func (r *HC165) PinFuncs() *gobot_sim.PinFuncs {
+	return &gobot_sim.PinFuncs{Write: r.pinWrite, Read: r.pinRead}
+}
+
+// pinWrite writes a virtual input pin
+func (r *HC165) pinWrite(pin string, v byte) error {
+	input, found := r.names[pin]
+	if !found {
+		return fmt.Errorf("pin %s is not an input of the 74HC165 chain", pin)
+	}
+	return r.SetInput(input, v)
+}
+
+// pinRead reads a virtual input pin
+func (r *HC165) pinRead(pin string) (int, error) {
+	input, found := r.names[pin]
+	if !found {
+		return 0, fmt.Errorf("pin %s is not an input of the 74HC165 chain", pin)
+	}
+	return r.Input(input), nil
+}
+
+// load loads the inputs while the load pin is low, and stops loading when it goes high
+func (r *HC165) load(ev gobot_sim.PinChangedEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.loading = ev.Value == gobot_sim.PIN_OFF
+	r.capture()
+	return nil
+}
+
+// clock shifts the bits toward the serial pin on the rising edge of the clock
+func (r *HC165) clock(ev gobot_sim.PinChangedEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.loading {
+		return nil
+	}
+	// SER of the last chip is expected to be tied to ground
+	copy(r.shift, r.shift[1:])
+	r.shift[len(r.shift)-1] = gobot_sim.PIN_OFF
+	return nil
+}
+
+// capture copies the inputs to the shift register in the order they are shifted out
+func (r *HC165) capture() {
+	for i := range r.shift {
+		chip, bit := i/8, 7-i%8
+		r.shift[i] = r.inputs[chip*8+bit]
+	}
+}
+
+// serialReadHook returns the read hook for the value file of the serial pin
+func (r *HC165) serialReadHook() hybrid_sysfs.ReadHookFunc {
+	return func(contents string) string {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		// while loading the inputs are fed through
+		if r.loading {
+			r.capture()
+		}
+		return strconv.Itoa(r.shift[0])
+	}
+}
+
+// AddHC165 adds a virtual chain of chips 74HC165 shift registers on the load,
+// clock and serial pins, see NewHC165. Map keys to its inputs with AddHC165Key
+func (sim *GobotSimulator) AddHC165(loadPin string, clockPin string, serialPin string, chips int) (*HC165, error) {
+	r, err := NewHC165(loadPin, clockPin, serialPin, chips)
+	if err != nil {
+		return nil, err
+	}
+	if err := sim.setReadHook(serialPin, r.serialReadHook()); err != nil {
+		return nil, err
+	}
+	w, err := sim.WatchPin(loadPin, r.load)
+	if err != nil {
+		return nil, err
+	}
+	// the inputs must be loaded when the load pin is written, polling would miss loads
+	w.SetMode(gobot_sim.WATCH_EVENTS)
+	w.SetName("74hc165")
+	w, err = sim.WatchPinEdges(clockPin, gobot_sim.EDGE_RISING, r.clock)
+	if err != nil {
+		return nil, err
+	}
+	w.SetMode(gobot_sim.WATCH_EVENTS)
+	w.SetName("74hc165")
+	sim.inputChains = append(sim.inputChains, r)
+	return r, nil
+}
+
+// AddHC165Key writes something to an input of a 74HC165 chain when a key is pressed,
+// like AddKeyPressPWAction does for a pin
+func (sim *GobotSimulator) AddHC165Key(key rune, r *HC165, input int, action int) (*gobot_sim.PinWriteAction, error) {
+	if input < 0 || input >= len(r.inputs) {
+		return nil, fmt.Errorf("input D%d does not exist, the chain has %d inputs", input, len(r.inputs))
+	}
+	ac := gobot_sim.NewPinWriteAction(r.InputPin(input), action, r.PinFuncs())
+	ac.SetOnOffValues(r.OnOffValues())
+	// the input is a virtual pin, the pins of the chain were intercepted by AddHC165
+	sim.mapKey(key, ac)
+	return ac, nil
+}
@@ -0,0 +1,127 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadInputs pulses the load pin of a 74HC165 chain low and high
+func loadInputs(t *testing.T, r *HC165) {
+	for _, v := range []int{0, 1} {
+		ev := gobot_sim.PinChangedEvent{Pin: r.loadPin, LastValue: 1 - v, Value: v, Time: time.Now()}
+		if err := r.load(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// shiftIn reads the serial pin of a 74HC165 chain n times, clocking after each read
+func shiftIn(t *testing.T, r *HC165, n int) string {
+	read := r.serialReadHook()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(read(""))
+		ev := gobot_sim.PinChangedEvent{Pin: r.clockPin, LastValue: 0, Value: 1, Time: time.Now()}
+		if err := r.clock(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.String()
+}
+
+func TestHC165ShiftOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		chips int
+		// on are the inputs that are on
+		on []int
+		// want are the bits that are shifted out, the first bit first
+		want string
+	}{
+		{"no inputs", 1, nil, "00000000"},
+		{"D7 comes first", 1, []int{7}, "10000000"},
+		{"D0 comes last", 1, []int{0}, "00000001"},
+		{"byte", 1, []int{0, 1, 4, 6}, "01010011"},
+		{"first chip comes first", 2, []int{7}, "1000000000000000"},
+		{"D8 comes last", 2, []int{8}, "0000000000000001"},
+		{"D15 follows D0", 2, []int{0, 15}, "0000000110000000"},
+		{"empty chain shifts in zeros", 1, []int{0, 1, 2, 3, 4, 5, 6, 7}, "1111111100000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewHC165("load", "clock", "serial", tt.chips)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, input := range tt.on {
+				if err := r.SetInput(input, gobot_sim.PIN_ON); err != nil {
+					t.Fatal(err)
+				}
+			}
+			loadInputs(t, r)
+			if got := shiftIn(t, r, len(tt.want)); got != tt.want {
+				t.Errorf("shifted out %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHC165Loading(t *testing.T) {
+	r, err := NewHC165("load", "clock", "serial", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := r.serialReadHook()
+	if err := r.load(gobot_sim.PinChangedEvent{Pin: "load", Value: 0}); err != nil {
+		t.Fatal(err)
+	}
+	// while loading D7 is fed through and the clock is ignored
+	if got := read(""); got != "0" {
+		t.Errorf("serial %s, want 0", got)
+	}
+	r.SetInput(7, gobot_sim.PIN_ON)
+	if got := read(""); got != "1" {
+		t.Errorf("serial %s after setting D7, want 1", got)
+	}
+	if got := shiftIn(t, r, 3); got != "111" {
+		t.Errorf("shifted out %s while loading, want 111", got)
+	}
+
+	// after loading the inputs are held
+	if err := r.load(gobot_sim.PinChangedEvent{Pin: "load", Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+	r.SetInput(7, gobot_sim.PIN_OFF)
+	if got := read(""); got != "1" {
+		t.Errorf("serial %s after loading, want 1", got)
+	}
+}
+
+func TestHC165InputPins(t *testing.T) {
+	r, err := NewHC165("load", "clock", "serial", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// buttons with pull up resistors
+	r.SetOnOffValues(gobot_sim.PIN_OFF, gobot_sim.PIN_ON)
+	funcs := r.PinFuncs()
+	if err := funcs.Write(r.InputPin(9), gobot_sim.PIN_OFF); err != nil {
+		t.Fatal(err)
+	}
+	if err := funcs.Write("165@load:D16", gobot_sim.PIN_OFF); err == nil {
+		t.Error("wrote D16 of a chain of 2 chips")
+	}
+	if v, err := funcs.Read("165@load:D9"); err != nil || v != gobot_sim.PIN_OFF {
+		t.Errorf("D9 is %d %v, want 0", v, err)
+	}
+	loadInputs(t, r)
+	want := "1111111111111101"
+	if got := shiftIn(t, r, 16); got != want {
+		t.Errorf("shifted out %s, want %s", got, want)
+	}
+	if got := r.Input(8); got != gobot_sim.PIN_ON {
+		t.Errorf("D8 is %d, want 1", got)
+	}
+}
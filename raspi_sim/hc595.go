@@ -0,0 +1,189 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HC595 is a virtual chain of 74HC595 serial in, parallel out shift registers.
+// A bit is shifted in from the data pin on the rising edge of the clock (SH_CP)
+// pin, and the outputs are updated on the rising edge of the latch (ST_CP) pin.
+// The outputs are virtual pins that can be read and watched like real pins.
+type HC595 struct {
+	dataPin  string
+	clockPin string
+	latchPin string
+	pinRead  gobot_sim.PinReadFunc
+
+	shift    []int
+	outputs  []int
+	watchers []hc595Watcher
+	onError  func(pin string, err error)
+	mutex    *sync.Mutex
+}
+
+// hc595Watcher is a watcher of an output
+type hc595Watcher struct {
+	watcher *gobot_sim.PinWatcher
+	output  int
+}
+
+// NewHC595 creates a chain of chips 74HC595s. The data pin is connected to DS of
+// the first chip and Q7' of each chip to DS of the next, so the outputs are
+// numbered Q0-Q7 on the first chip, Q8-Q15 on the second chip etc
+func NewHC595(dataPin string, clockPin string, latchPin string, chips int, pinRead gobot_sim.PinReadFunc) (*HC595, error) {
+	if chips < 1 {
+		return nil, fmt.Errorf("a 74HC595 chain needs at least one chip, not %d", chips)
+	}
+	return &HC595{
+		dataPin:  dataPin,
+		clockPin: clockPin,
+		latchPin: latchPin,
+		pinRead:  pinRead,
+		shift:    make([]int, chips*8),
+		outputs:  make([]int, chips*8),
+		mutex:    &sync.Mutex{},
+	}, nil
+}
+
+// Pins returns the data, clock and latch pins
+func (r *HC595) Pins() []string {
+	return []string{r.dataPin, r.clockPin, r.latchPin}
+}
+
+// OutputPin returns the name of the virtual pin of an output, i.e. "595@13:Q3"
+// for Q3 of a chain with the latch on pin 13
+func (r *HC595) OutputPin(output int) string {
+	return fmt.Sprintf("595@%s:Q%d", r.latchPin, output)
+}
+
+// Output returns the value of an output
+func (r *HC595) Output(output int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.outputs[output]
+}
+
+// Outputs returns the values of all outputs, starting with Q0 of the first chip
+func (r *HC595) Outputs() []int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]int{}, r.outputs...)
+}
+
+// String returns the outputs as bits, starting with Q0 of the first chip
+func (r *HC595) String() string {
+	var b strings.Builder
+	for _, v := range r.Outputs() {
+		b.WriteString(strconv.Itoa(v))
+	}
+	return b.String()
+}
+
+// Watch adds a watcher for an output, that is observed whenever the outputs are latched
+func (r *HC595) Watch(output int, handler gobot_sim.PinChangedFunc) (*gobot_sim.PinWatcher, error) {
+	if output < 0 || output >= len(r.outputs) {
+		return nil, fmt.Errorf("output Q%d does not exist, the chain has %d outputs", output, len(r.outputs))
+	}
+	read := func(pin string) (int, error) {
+		return r.Output(output), nil
+	}
+	watchFuncs := &gobot_sim.WatchFuncs{Read: read, Changed: handler}
+	watcher := gobot_sim.NewPinWatcher(r.OutputPin(output), watchFuncs)
+	watcher.SetMode(gobot_sim.WATCH_EVENTS)
+	// the current value is needed to detect the first change
+	if err := watcher.Observe(); err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	r.watchers = append(r.watchers, hc595Watcher{watcher: watcher, output: output})
+	r.mutex.Unlock()
+	return watcher, nil
+}
+
+// Unwatch removes a watcher that was added with Watch
+func (r *HC595) Unwatch(watcher *gobot_sim.PinWatcher) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, w := range r.watchers {
+		if w.watcher == watcher {
+			r.watchers = append(r.watchers[:i], r.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// clock shifts in the data pin on the rising edge of the clock
+func (r *HC595) clock(ev gobot_sim.PinChangedEvent) error {
+	data, err := r.pinRead(r.dataPin)
+	if err != nil {
+		return err
+	}
+	bit := gobot_sim.PIN_OFF
+	if data == gobot_sim.PIN_ON {
+		bit = gobot_sim.PIN_ON
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	copy(r.shift[1:], r.shift)
+	r.shift[0] = bit
+	return nil
+}
+
+// latch copies the shift register to the outputs on the rising edge of the latch
+// and notifies the watchers of the outputs
+func (r *HC595) latch(ev gobot_sim.PinChangedEvent) error {
+	r.mutex.Lock()
+	copy(r.outputs, r.shift)
+	outputs := append([]int{}, r.outputs...)
+	watchers := append([]hc595Watcher{}, r.watchers...)
+	onError := r.onError
+	r.mutex.Unlock()
+
+	var latchErr error
+	for _, w := range watchers {
+		err := w.watcher.ObserveValue(outputs[w.output])
+		if err == nil {
+			continue
+		}
+		if onError != nil {
+			onError(w.watcher.Pin(), err)
+		} else if latchErr == nil {
+			latchErr = err
+		}
+	}
+	return latchErr
+}
+
+// AddHC595 adds a virtual chain of chips 74HC595 shift registers on the data,
+// clock and latch pins, see NewHC595. Watch its outputs with HC595.Watch
+func (sim *GobotSimulator) AddHC595(dataPin string, clockPin string, latchPin string, chips int) (*HC595, error) {
+	r, err := NewHC595(dataPin, clockPin, latchPin, chips, sim.pinRead)
+	if err != nil {
+		return nil, err
+	}
+	r.onError = func(pin string, err error) {
+		sim.handleError("watcher", []string{pin}, err)
+	}
+	if err := sim.usePinForGPIO(dataPin); err != nil {
+		return nil, err
+	}
+	// the data must be shifted in when the clock is written, polling would miss bits
+	for pin, handler := range map[string]gobot_sim.PinChangedFunc{clockPin: r.clock, latchPin: r.latch} {
+		w, err := sim.WatchPinEdges(pin, gobot_sim.EDGE_RISING, handler)
+		if err != nil {
+			return nil, err
+		}
+		w.SetMode(gobot_sim.WATCH_EVENTS)
+		w.SetName("74hc595")
+	}
+	return r, nil
+}
@@ -0,0 +1,101 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shiftOut shifts bits into a 74HC595 chain, the first bit first
+func shiftOut(t *testing.T, r *HC595, pins stubPins, bits string) {
+	for _, bit := range bits {
+		pins[r.dataPin] = int(bit - '0')
+		ev := gobot_sim.PinChangedEvent{Pin: r.clockPin, LastValue: 0, Value: 1, Time: time.Now()}
+		if err := r.clock(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// latch595 latches the outputs of a 74HC595 chain
+func latch595(t *testing.T, r *HC595) {
+	ev := gobot_sim.PinChangedEvent{Pin: r.latchPin, LastValue: 0, Value: 1, Time: time.Now()}
+	if err := r.latch(ev); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHC595ShiftOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		chips int
+		bits  string
+		// want are the outputs, starting with Q0 of the first chip
+		want string
+	}{
+		{"first bit ends in Q7", 1, "10000000", "00000001"},
+		{"last bit ends in Q0", 1, "00000001", "10000000"},
+		{"byte", 1, "11010010", "01001011"},
+		{"partial byte", 1, "101", "10100000"},
+		{"overflow", 1, "1111111100000000", "00000000"},
+		{"first bit ends in Q15", 2, "1000000000000000", "0000000000000001"},
+		{"second chip gets the first byte", 2, "1100000000000001", "1000000000000011"},
+		{"three chips", 3, "111000000000000000000001", "100000000000000000000111"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins := stubPins{}
+			r, err := NewHC595("data", "clock", "latch", tt.chips, pins.read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shiftOut(t, r, pins, tt.bits)
+			if got := r.String(); got != strings.Repeat("0", tt.chips*8) {
+				t.Errorf("outputs %s before latch, want all off", got)
+			}
+			latch595(t, r)
+			if got := r.String(); got != tt.want {
+				t.Errorf("outputs %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHC595Watch(t *testing.T) {
+	pins := stubPins{}
+	r, err := NewHC595("data", "clock", "latch", 1, pins.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []gobot_sim.PinChangedEvent
+	w, err := r.Watch(0, func(ev gobot_sim.PinChangedEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Watch(8, nil); err == nil {
+		t.Error("watched Q8 of a single chip")
+	}
+
+	for _, bits := range []string{"1", "1", "0"} {
+		shiftOut(t, r, pins, bits)
+		latch595(t, r)
+	}
+	// Q0 goes on, stays on and goes off
+	if len(events) != 2 || events[0].Value != 1 || events[1].Value != 0 {
+		t.Fatalf("events %+v, want on and off", events)
+	}
+	if events[0].Pin != "595@latch:Q0" {
+		t.Errorf("pin %s, want 595@latch:Q0", events[0].Pin)
+	}
+
+	r.Unwatch(w)
+	shiftOut(t, r, pins, "1")
+	latch595(t, r)
+	if len(events) != 2 {
+		t.Errorf("%d events after unwatch, want 2", len(events))
+	}
+}
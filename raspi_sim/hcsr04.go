@@ -0,0 +1,305 @@
+package raspi_sim
+
+import (
+	"context"
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/rs/zerolog/log"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// timing of a HC-SR04 ultrasonic distance sensor
+const (
+	// HCSR04EchoPerCm is how long the echo pin is high per cm of distance,
+	// the time sound takes to travel a cm and back
+	HCSR04EchoPerCm = time.Microsecond * 58
+	// HCSR04Timeout is how long the echo pin is high when no echo is received
+	HCSR04Timeout = time.Millisecond * 38
+	// HCSR04MinRange and HCSR04MaxRange are the distances in cm that can be measured
+	HCSR04MinRange = 2.0
+	HCSR04MaxRange = 400.0
+	// HCSR04MinTrigger is the minimum width of a trigger pulse
+	HCSR04MinTrigger = time.Microsecond * 10
+	// hcsr04Burst is the time between the trigger and the echo, when the sensor sends its burst
+	hcsr04Burst = time.Microsecond * 500
+	// hcsr04Spin is how long before an edge the echo stops sleeping and spins, to time it precisely
+	hcsr04Spin = time.Millisecond
+)
+
+// DistanceStep is a step of a distance profile: the distance in cm is reached
+// linearly over the duration, or at once if the duration is 0
+type DistanceStep struct {
+	Distance float64
+	Duration time.Duration
+}
+
+// HCSR04 is a virtual HC-SR04 ultrasonic distance sensor. After a pulse on the
+// trigger pin it drives the echo pin high for 58µs per cm of distance to the
+// obstacle, or for 38ms when there is no obstacle within range.
+// The distance can be set from code, with keys or by a scripted profile.
+type HCSR04 struct {
+	triggerPin string
+	echoPin    string
+
+	distance     float64
+	profile      []DistanceStep
+	repeat       bool
+	profileStart time.Time
+	profileFrom  float64
+	triggeredAt  time.Time
+	busy         bool
+	measurements int
+	running      bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mutex        *sync.Mutex
+
+	pinFuncs *gobot_sim.PinFuncs
+	onError  func(pin string, err error)
+}
+
+// AddHCSR04 adds a virtual HC-SR04 ultrasonic distance sensor on the trigger
+// and echo pins, with an obstacle at a distance in cm
+func (sim *GobotSimulator) AddHCSR04(triggerPin string, echoPin string, distance float64) (*HCSR04, error) {
+	if err := sim.usePinForGPIO(echoPin); err != nil {
+		return nil, err
+	}
+	s := &HCSR04{
+		triggerPin: triggerPin,
+		echoPin:    echoPin,
+		distance:   distance,
+		mutex:      &sync.Mutex{},
+		pinFuncs:   sim.PinFuncs(),
+		onError: func(pin string, err error) {
+			sim.handleError("hc-sr04", []string{pin}, err)
+		},
+	}
+	w, err := sim.WatchPin(triggerPin, s.trigger)
+	if err != nil {
+		return nil, err
+	}
+	// the width of the trigger pulse is measured from the writes
+	w.SetMode(gobot_sim.WATCH_EVENTS)
+	w.SetName("hc-sr04")
+	sim.distanceSensors = append(sim.distanceSensors, s)
+	return s, nil
+}
+
+// Pins returns the trigger and echo pins
+func (s *HCSR04) Pins() []string {
+	return []string{s.triggerPin, s.echoPin}
+}
+
+// SetDistance sets the distance to the obstacle in cm, and stops a profile.
+// A distance beyond HCSR04MaxRange, i.e. math.Inf(1), means there is no obstacle
+func (s *HCSR04) SetDistance(distance float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.distance = distance
+	s.profile = nil
+}
+
+// Distance returns the current distance to the obstacle in cm
+func (s *HCSR04) Distance() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.currentDistance(time.Now())
+}
+
+// Adjust changes the distance to the obstacle by delta cm, and stops a profile.
+// The distance does not go below 0
+func (s *HCSR04) Adjust(delta float64) {
+	s.SetDistance(max0(s.Distance() + delta))
+}
+
+// AdjustAction returns an action that changes the distance by delta cm,
+// so the obstacle can be moved with keys
+func (s *HCSR04) AdjustAction(delta float64) *DistanceAdjustAction {
+	return &DistanceAdjustAction{sensor: s, delta: delta}
+}
+
+// SetProfile moves the obstacle through the steps of a profile, starting at the
+// current distance. With repeat the profile starts over after the last step,
+// otherwise the obstacle stays at the last distance. The profile starts when it
+// is set, or when the simulator starts running
+func (s *HCSR04) SetProfile(steps []DistanceStep, repeat bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.profileFrom = s.currentDistance(now)
+	s.profile = steps
+	s.repeat = repeat
+	s.profileStart = now
+}
+
+// Measurements returns the number of measurements the sensor made
+func (s *HCSR04) Measurements() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.measurements
+}
+
+// currentDistance returns the distance at a time, following the profile
+func (s *HCSR04) currentDistance(now time.Time) float64 {
+	if len(s.profile) == 0 {
+		return s.distance
+	}
+	var total time.Duration
+	for _, step := range s.profile {
+		total += step.Duration
+	}
+	elapsed := now.Sub(s.profileStart)
+	if s.repeat && total > 0 {
+		elapsed %= total
+	}
+	from := s.profileFrom
+	for _, step := range s.profile {
+		if elapsed < step.Duration {
+			return from + (step.Distance-from)*float64(elapsed)/float64(step.Duration)
+		}
+		elapsed -= step.Duration
+		from = step.Distance
+	}
+	return from
+}
+
+// echoDuration returns how long the echo pin is high for a distance
+func echoDuration(distance float64) time.Duration {
+	if math.IsNaN(distance) || distance > HCSR04MaxRange {
+		return HCSR04Timeout
+	}
+	if distance < HCSR04MinRange {
+		distance = HCSR04MinRange
+	}
+	return time.Duration(distance * float64(HCSR04EchoPerCm))
+}
+
+// trigger starts a measurement on the falling edge of a long enough trigger pulse
+func (s *HCSR04) trigger(ev gobot_sim.PinChangedEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ev.Value == gobot_sim.PIN_ON {
+		s.triggeredAt = ev.Time
+		return nil
+	}
+	if s.triggeredAt.IsZero() || !s.running {
+		return nil
+	}
+	width := ev.Time.Sub(s.triggeredAt)
+	s.triggeredAt = time.Time{}
+	if width < HCSR04MinTrigger {
+		log.Debug().Str("pin", s.triggerPin).Dur("width", width).Msg("hc-sr04 - trigger pulse too short")
+		return nil
+	}
+	if s.busy {
+		// the sensor ignores triggers while measuring
+		return nil
+	}
+	s.busy = true
+	s.measurements++
+	go s.echo(s.ctx, ev.Time, echoDuration(s.currentDistance(ev.Time)))
+	return nil
+}
+
+// echo drives the echo pin high for a duration, after the burst
+func (s *HCSR04) echo(ctx context.Context, triggered time.Time, duration time.Duration) {
+	defer func() {
+		s.mutex.Lock()
+		s.busy = false
+		s.mutex.Unlock()
+	}()
+
+	if !sleepUntil(ctx, triggered.Add(hcsr04Burst)) {
+		return
+	}
+	s.write(gobot_sim.PIN_ON)
+	// the width is what is measured, so it is timed from the actual rise.
+	// When cancelled the echo ends at once
+	sleepUntil(ctx, time.Now().Add(duration))
+	s.write(gobot_sim.PIN_OFF)
+}
+
+// write writes a value to the echo pin
+func (s *HCSR04) write(v byte) {
+	if err := s.pinFuncs.Write(s.echoPin, v); err != nil {
+		s.onError(s.echoPin, err)
+	}
+}
+
+// start starts the sensor and the profile when the simulator runs
+func (s *HCSR04) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ctx, s.cancel = ctx, cancel
+	s.running = true
+	s.profileStart = time.Now()
+}
+
+// Stop stops the sensor, a pending echo ends at once
+func (s *HCSR04) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// sleepUntil sleeps until a time and spins the last part, because timers are
+// not precise enough for echoes of a few cm. It returns false when cancelled
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	if d := time.Until(t) - hcsr04Spin; d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+	}
+	for time.Now().Before(t) {
+		if ctx.Err() != nil {
+			return false
+		}
+		// let other goroutines run, i.e. on a single core
+		runtime.Gosched()
+	}
+	return true
+}
+
+var _ gobot_sim.Action = (*DistanceAdjustAction)(nil)
+
+// DistanceAdjustAction moves the obstacle of a distance sensor, so it can be mapped to a key
+type DistanceAdjustAction struct {
+	sensor *HCSR04
+	delta  float64
+}
+
+// String describes the action
+func (ac *DistanceAdjustAction) String() string {
+	return fmt.Sprintf("distance %+g cm", ac.delta)
+}
+
+// Pins returns the pins of the sensor
+func (ac *DistanceAdjustAction) Pins() []string {
+	return ac.sensor.Pins()
+}
+
+// Execute moves the obstacle
+func (ac *DistanceAdjustAction) Execute() error {
+	ac.sensor.Adjust(ac.delta)
+	log.Info().Str("pin", ac.sensor.echoPin).Float64("distance", ac.sensor.Distance()).
+		Msg("hc-sr04 - adjusted")
+	return nil
+}
@@ -0,0 +1,109 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHCSR04EchoDuration(t *testing.T) {
+	tests := []struct {
+		distance float64
+		want     time.Duration
+	}{
+		{100, time.Microsecond * 5800},
+		{20, time.Microsecond * 1160},
+		{HCSR04MinRange, time.Microsecond * 116},
+		{1, time.Microsecond * 116},
+		{0, time.Microsecond * 116},
+		{HCSR04MaxRange, time.Microsecond * 23200},
+		{401, HCSR04Timeout},
+		{math.Inf(1), HCSR04Timeout},
+		{math.NaN(), HCSR04Timeout},
+	}
+	for _, tt := range tests {
+		if got := echoDuration(tt.distance); got != tt.want {
+			t.Errorf("distance %g: echo %s, want %s", tt.distance, got, tt.want)
+		}
+	}
+}
+
+// echoWrite is a write to the echo pin and when it happened
+type echoWrite struct {
+	v  byte
+	at time.Time
+}
+
+// newTestHCSR04 creates a running sensor whose echo writes are sent on a channel
+func newTestHCSR04(t *testing.T, distance float64) (*HCSR04, chan echoWrite) {
+	sensor, err := newTestSimulator().AddHCSR04("13", "15", distance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := make(chan echoWrite, 8)
+	sensor.pinFuncs = &gobot_sim.PinFuncs{Write: func(pin string, v byte) error {
+		writes <- echoWrite{v: v, at: time.Now()}
+		return nil
+	}}
+	sensor.start()
+	t.Cleanup(sensor.Stop)
+	return sensor, writes
+}
+
+// triggerPulse triggers a sensor with a pulse of a width, ending now
+func triggerPulse(t *testing.T, s *HCSR04, width time.Duration) {
+	now := time.Now()
+	for _, ev := range []gobot_sim.PinChangedEvent{
+		{Pin: s.triggerPin, LastValue: 0, Value: 1, Time: now.Add(-width)},
+		{Pin: s.triggerPin, LastValue: 1, Value: 0, Time: now},
+	} {
+		if err := s.trigger(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHCSR04Echo(t *testing.T) {
+	tests := []struct {
+		name     string
+		distance float64
+		want     time.Duration
+	}{
+		{"20 cm", 20, time.Microsecond * 1160},
+		{"1 m", 100, time.Microsecond * 5800},
+		{"out of range", math.Inf(1), HCSR04Timeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sensor, writes := newTestHCSR04(t, tt.distance)
+			triggerPulse(t, sensor, HCSR04MinTrigger)
+
+			rise, fall := <-writes, <-writes
+			if rise.v != gobot_sim.PIN_ON || fall.v != gobot_sim.PIN_OFF {
+				t.Fatalf("echo wrote %d and %d, want a pulse", rise.v, fall.v)
+			}
+			// the echo is timed by spinning, but the test may be descheduled between
+			// the write and taking its time
+			if width := fall.at.Sub(rise.at); width < tt.want || width > tt.want+time.Millisecond*2 {
+				t.Errorf("echo width %s, want %s", width, tt.want)
+			}
+			if sensor.Measurements() != 1 {
+				t.Errorf("%d measurements, want 1", sensor.Measurements())
+			}
+		})
+	}
+}
+
+func TestHCSR04ShortTrigger(t *testing.T) {
+	sensor, writes := newTestHCSR04(t, 20)
+	triggerPulse(t, sensor, HCSR04MinTrigger/2)
+	select {
+	case w := <-writes:
+		t.Errorf("echo wrote %d after a trigger pulse that is too short", w.v)
+	case <-time.After(time.Millisecond * 10):
+	}
+	if sensor.Measurements() != 0 {
+		t.Errorf("%d measurements, want 0", sensor.Measurements())
+	}
+}
@@ -0,0 +1,379 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"io"
+	"strings"
+	"sync"
+)
+
+// HD44780CustomChar is shown for the custom characters in CGRAM
+const HD44780CustomChar = '▒'
+
+// HD44780 is a virtual character LCD with a HD44780 controller. It decodes the
+// instructions and data that are written to the RS and data pins on the falling
+// edge of the E pin, in 4 bit (D4-D7) or 8 bit (D0-D7) mode. The RW pin is
+// expected to be tied to ground, so the display is never read.
+type HD44780 struct {
+	rsPin    string
+	ePin     string
+	dataPins []string
+	cols     int
+	rows     int
+	pinRead  gobot_sim.PinReadFunc
+
+	eightBit  bool
+	twoLine   bool
+	highBits  byte
+	lowNibble bool
+
+	ddram     [0x80]byte
+	cgram     [0x40]byte
+	address   int
+	cgramMode bool
+	increment bool
+	shiftMode bool
+	shift     int
+
+	displayOn bool
+	cursorOn  bool
+	blinkOn   bool
+
+	output   io.Writer
+	rendered string
+	mutex    *sync.Mutex
+}
+
+// NewHD44780 creates a virtual LCD with cols x rows characters, i.e. 16x2.
+// dataPins are D4-D7 for a display wired in 4 bit mode or D0-D7 in 8 bit mode
+func NewHD44780(rsPin string, ePin string, dataPins []string, cols int, rows int, pinRead gobot_sim.PinReadFunc) (*HD44780, error) {
+	if len(dataPins) != 4 && len(dataPins) != 8 {
+		return nil, fmt.Errorf("a HD44780 has 4 or 8 data pins, not %d", len(dataPins))
+	}
+	if rows < 1 || rows > 4 || cols < 1 || cols > 40 {
+		return nil, fmt.Errorf("invalid display size %dx%d", cols, rows)
+	}
+	lcd := &HD44780{
+		rsPin:    rsPin,
+		ePin:     ePin,
+		dataPins: dataPins,
+		cols:     cols,
+		rows:     rows,
+		pinRead:  pinRead,
+		// the controller powers up in 8 bit mode
+		eightBit:  true,
+		increment: true,
+		mutex:     &sync.Mutex{},
+	}
+	lcd.clear()
+	return lcd, nil
+}
+
+// Pins returns the RS, E and data pins
+func (lcd *HD44780) Pins() []string {
+	return append([]string{lcd.rsPin, lcd.ePin}, lcd.dataPins...)
+}
+
+// SetOutput renders the display to w whenever its contents change, nil disables rendering
+func (lcd *HD44780) SetOutput(w io.Writer) {
+	lcd.mutex.Lock()
+	defer lcd.mutex.Unlock()
+
+	lcd.output = w
+}
+
+// Lines returns the visible text of each row. Custom characters are HD44780CustomChar
+func (lcd *HD44780) Lines() []string {
+	lcd.mutex.Lock()
+	defer lcd.mutex.Unlock()
+
+	return lcd.lines()
+}
+
+// Text returns the visible text, with a line for each row
+func (lcd *HD44780) Text() string {
+	return strings.Join(lcd.Lines(), "\n")
+}
+
+// DisplayOn returns whether the display is switched on
+func (lcd *HD44780) DisplayOn() bool {
+	lcd.mutex.Lock()
+	defer lcd.mutex.Unlock()
+
+	return lcd.displayOn
+}
+
+// Cursor returns the column and row of the cursor, and whether it is shown
+func (lcd *HD44780) Cursor() (int, int, bool) {
+	lcd.mutex.Lock()
+	defer lcd.mutex.Unlock()
+
+	for row := 0; row < lcd.rows; row++ {
+		for col := 0; col < lcd.cols; col++ {
+			if lcd.visibleAddress(col, row) == lcd.address && !lcd.cgramMode {
+				return col, row, lcd.cursorOn || lcd.blinkOn
+			}
+		}
+	}
+	return -1, -1, false
+}
+
+// CustomChar returns the pixel rows of a custom character
+func (lcd *HD44780) CustomChar(pos int) [8]byte {
+	lcd.mutex.Lock()
+	defer lcd.mutex.Unlock()
+
+	var char [8]byte
+	copy(char[:], lcd.cgram[(pos&0x07)*8:])
+	return char
+}
+
+// Render writes the display in a frame
+func (lcd *HD44780) Render(w io.Writer) error {
+	_, err := io.WriteString(w, lcd.frame(lcd.Lines()))
+	return err
+}
+
+// frame draws lines in a frame
+func (lcd *HD44780) frame(lines []string) string {
+	border := "+" + strings.Repeat("-", lcd.cols) + "+\n"
+	var b strings.Builder
+	b.WriteString(border)
+	for _, line := range lines {
+		b.WriteString("|" + line + "|\n")
+	}
+	b.WriteString(border)
+	return b.String()
+}
+
+// strobe latches the RS and data pins on the falling edge of E
+func (lcd *HD44780) strobe(ev gobot_sim.PinChangedEvent) error {
+	rs, err := lcd.pinRead(lcd.rsPin)
+	if err != nil {
+		return err
+	}
+	var value byte
+	for i, pin := range lcd.dataPins {
+		v, err := lcd.pinRead(pin)
+		if err != nil {
+			return err
+		}
+		if v == gobot_sim.PIN_ON {
+			value |= 1 << uint(i+8-len(lcd.dataPins))
+		}
+	}
+
+	lcd.mutex.Lock()
+	if lcd.eightBit {
+		lcd.execute(rs == gobot_sim.PIN_ON, value)
+	} else if !lcd.lowNibble {
+		lcd.highBits = value & 0xf0
+		lcd.lowNibble = true
+	} else {
+		lcd.lowNibble = false
+		lcd.execute(rs == gobot_sim.PIN_ON, lcd.highBits|value>>4)
+	}
+	lcd.mutex.Unlock()
+
+	lcd.render()
+	return nil
+}
+
+// execute executes an instruction, or writes data if rs is set
+func (lcd *HD44780) execute(rs bool, b byte) {
+	if rs {
+		lcd.write(b)
+		return
+	}
+	switch {
+	case b&0x80 != 0:
+		lcd.address = int(b & 0x7f)
+		lcd.cgramMode = false
+	case b&0x40 != 0:
+		lcd.address = int(b & 0x3f)
+		lcd.cgramMode = true
+	case b&0x20 != 0:
+		lcd.eightBit = b&0x10 != 0
+		lcd.twoLine = b&0x08 != 0
+		lcd.lowNibble = false
+	case b&0x10 != 0:
+		right := b&0x04 != 0
+		if b&0x08 != 0 {
+			lcd.shiftDisplay(right)
+		} else {
+			lcd.moveCursor(right)
+		}
+	case b&0x08 != 0:
+		lcd.displayOn = b&0x04 != 0
+		lcd.cursorOn = b&0x02 != 0
+		lcd.blinkOn = b&0x01 != 0
+	case b&0x04 != 0:
+		lcd.increment = b&0x02 != 0
+		lcd.shiftMode = b&0x01 != 0
+	case b&0x02 != 0:
+		lcd.address = 0
+		lcd.cgramMode = false
+		lcd.shift = 0
+	case b&0x01 != 0:
+		lcd.clear()
+	}
+}
+
+// write writes data to DDRAM or CGRAM at the address counter
+func (lcd *HD44780) write(b byte) {
+	if lcd.cgramMode {
+		lcd.cgram[lcd.address] = b & 0x1f
+		if lcd.increment {
+			lcd.address = (lcd.address + 1) % len(lcd.cgram)
+		} else {
+			lcd.address = (lcd.address + len(lcd.cgram) - 1) % len(lcd.cgram)
+		}
+		return
+	}
+	lcd.ddram[lcd.address] = b
+	lcd.moveCursor(lcd.increment)
+	if lcd.shiftMode {
+		lcd.shiftDisplay(!lcd.increment)
+	}
+}
+
+// clear fills DDRAM with spaces and returns home
+func (lcd *HD44780) clear() {
+	for i := range lcd.ddram {
+		lcd.ddram[i] = ' '
+	}
+	lcd.address = 0
+	lcd.cgramMode = false
+	lcd.shift = 0
+	lcd.increment = true
+}
+
+// moveCursor moves the address counter one position, wrapping like the controller does
+func (lcd *HD44780) moveCursor(right bool) {
+	if !lcd.twoLine {
+		if right {
+			lcd.address = (lcd.address + 1) % 80
+		} else {
+			lcd.address = (lcd.address + 79) % 80
+		}
+		return
+	}
+	switch {
+	case right && lcd.address == 0x27:
+		lcd.address = 0x40
+	case right && lcd.address >= 0x67:
+		lcd.address = 0x00
+	case !right && lcd.address == 0x40:
+		lcd.address = 0x27
+	case !right && lcd.address == 0x00:
+		lcd.address = 0x67
+	case right:
+		lcd.address++
+	default:
+		lcd.address--
+	}
+}
+
+// shiftDisplay shifts the visible window over DDRAM
+func (lcd *HD44780) shiftDisplay(right bool) {
+	if right {
+		lcd.shift--
+	} else {
+		lcd.shift++
+	}
+	lcd.shift = (lcd.shift + lcd.lineLength()) % lcd.lineLength()
+}
+
+// lineLength returns the number of DDRAM positions of a line
+func (lcd *HD44780) lineLength() int {
+	if lcd.twoLine {
+		return 40
+	}
+	return 80
+}
+
+// visibleAddress returns the DDRAM address shown at a position of the display.
+// Rows 3 and 4 of a 4 line display continue rows 1 and 2
+func (lcd *HD44780) visibleAddress(col int, row int) int {
+	if !lcd.twoLine {
+		return (row*lcd.cols + col + lcd.shift) % 80
+	}
+	base := []int{0x00, 0x40, 0x00, 0x40}[row]
+	offset := col + lcd.shift
+	if row >= 2 {
+		offset += lcd.cols
+	}
+	return base + offset%40
+}
+
+// lines returns the visible text
+func (lcd *HD44780) lines() []string {
+	lines := make([]string, lcd.rows)
+	for row := range lines {
+		text := make([]rune, lcd.cols)
+		for col := range text {
+			text[col] = hd44780Rune(lcd.ddram[lcd.visibleAddress(col, row)])
+		}
+		if !lcd.displayOn {
+			text = []rune(strings.Repeat(" ", lcd.cols))
+		}
+		lines[row] = string(text)
+	}
+	return lines
+}
+
+// render renders the display to the output if its contents changed
+func (lcd *HD44780) render() {
+	lcd.mutex.Lock()
+	defer lcd.mutex.Unlock()
+
+	if lcd.output == nil {
+		return
+	}
+	frame := lcd.frame(lcd.lines())
+	if frame == lcd.rendered {
+		return
+	}
+	lcd.rendered = frame
+	io.WriteString(lcd.output, frame)
+}
+
+// hd44780Rune translates a character code of the A00 character ROM
+func hd44780Rune(b byte) rune {
+	switch {
+	case b < 0x10:
+		return HD44780CustomChar
+	case b == 0x5c:
+		return '¥'
+	case b == 0x7e:
+		return '→'
+	case b == 0x7f:
+		return '←'
+	case b < 0x20 || b > 0x7f:
+		return ' '
+	}
+	return rune(b)
+}
+
+// AddHD44780 adds a virtual character LCD of cols x rows characters on the
+// RS, E and data pins (D4-D7 in 4 bit mode or D0-D7 in 8 bit mode)
+func (sim *GobotSimulator) AddHD44780(rsPin string, ePin string, dataPins []string, cols int, rows int) (*HD44780, error) {
+	lcd, err := NewHD44780(rsPin, ePin, dataPins, cols, rows, sim.pinRead)
+	if err != nil {
+		return nil, err
+	}
+	for _, pin := range lcd.Pins() {
+		if err := sim.usePinForGPIO(pin); err != nil {
+			return nil, err
+		}
+	}
+	w, err := sim.WatchPinEdges(ePin, gobot_sim.EDGE_FALLING, lcd.strobe)
+	if err != nil {
+		return nil, err
+	}
+	// the data must be latched when E is written, polling would miss strobes
+	w.SetMode(gobot_sim.WATCH_EVENTS)
+	w.SetName("hd44780")
+	return lcd, nil
+}
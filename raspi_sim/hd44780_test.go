@@ -0,0 +1,180 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubPins are pin values for a stub gobot_sim.PinReadFunc
+type stubPins map[string]int
+
+// read reads a pin, pins that are not set are off
+func (p stubPins) read(pin string) (int, error) {
+	return p[pin], nil
+}
+
+// lcdWrite is an instruction, or data if rs is set
+type lcdWrite struct {
+	rs bool
+	b  byte
+}
+
+// lcdText returns the data writes for a text
+func lcdText(text string) []lcdWrite {
+	var writes []lcdWrite
+	for _, c := range []byte(text) {
+		writes = append(writes, lcdWrite{rs: true, b: c})
+	}
+	return writes
+}
+
+// newTestLCD creates a LCD in 4 bit mode on stub pins
+func newTestLCD(t *testing.T, cols int, rows int) (*HD44780, stubPins) {
+	pins := stubPins{}
+	lcd, err := NewHD44780("rs", "e", []string{"d4", "d5", "d6", "d7"}, cols, rows, pins.read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lcd, pins
+}
+
+// strobeNibble sets RS and D4-D7 and strobes E
+func strobeNibble(t *testing.T, lcd *HD44780, pins stubPins, rs bool, nibble byte) {
+	pins["rs"] = gobot_sim.PIN_OFF
+	if rs {
+		pins["rs"] = gobot_sim.PIN_ON
+	}
+	for i, pin := range lcd.dataPins {
+		pins[pin] = int(nibble>>uint(i)) & 1
+	}
+	ev := gobot_sim.PinChangedEvent{Pin: "e", LastValue: 1, Value: 0, Time: time.Now()}
+	if err := lcd.strobe(ev); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// initLCD runs the 4 bit initialization of a HD44780 library, for 2 lines
+func initLCD(t *testing.T, lcd *HD44780, pins stubPins) {
+	for _, nibble := range []byte{0x3, 0x3, 0x3, 0x2} {
+		strobeNibble(t, lcd, pins, false, nibble)
+	}
+	writeLCD(t, lcd, pins, []lcdWrite{{b: 0x28}, {b: 0x0c}, {b: 0x06}, {b: 0x01}})
+}
+
+// writeLCD writes bytes as pairs of nibbles, high nibble first
+func writeLCD(t *testing.T, lcd *HD44780, pins stubPins, writes []lcdWrite) {
+	for _, w := range writes {
+		strobeNibble(t, lcd, pins, w.rs, w.b>>4)
+		strobeNibble(t, lcd, pins, w.rs, w.b&0x0f)
+	}
+}
+
+func TestHD44780FourBit(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []lcdWrite
+		want   []string
+	}{
+		{"text", lcdText("Hello"), []string{"Hello           ", "                "}},
+		{"second line", append([]lcdWrite{{b: 0xc0 | 0x02}}, lcdText("ab")...),
+			[]string{"                ", "  ab            "}},
+		{"clear", append(append(lcdText("abc"), lcdWrite{b: 0x01}), lcdText("d")...),
+			[]string{"d               ", "                "}},
+		{"return home", append(append(lcdText("abc"), lcdWrite{b: 0x02}), lcdText("x")...),
+			[]string{"xbc             ", "                "}},
+		{"display off", append(lcdText("abc"), lcdWrite{b: 0x08}),
+			[]string{"                ", "                "}},
+		{"decrement", append([]lcdWrite{{b: 0x80 | 0x03}, {b: 0x04}}, lcdText("ab")...),
+			[]string{"  ba            ", "                "}},
+		{"custom char", append(append([]lcdWrite{{b: 0x40}}, lcdText("\x1f")...), lcdWrite{b: 0x80}, lcdWrite{rs: true}),
+			[]string{string(HD44780CustomChar) + "               ", "                "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lcd, pins := newTestLCD(t, 16, 2)
+			initLCD(t, lcd, pins)
+			writeLCD(t, lcd, pins, tt.writes)
+			if got := lcd.Lines(); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHD44780NibblePairing(t *testing.T) {
+	lcd, pins := newTestLCD(t, 16, 2)
+	// until the function set the controller is in 8 bit mode, each nibble is an instruction
+	for _, nibble := range []byte{0x3, 0x3, 0x3} {
+		strobeNibble(t, lcd, pins, false, nibble)
+		if !lcd.eightBit || lcd.lowNibble {
+			t.Fatalf("nibble %x: eightBit %v lowNibble %v, want 8 bit mode", nibble, lcd.eightBit, lcd.lowNibble)
+		}
+	}
+	strobeNibble(t, lcd, pins, false, 0x2)
+	if lcd.eightBit {
+		t.Fatal("still in 8 bit mode after function set")
+	}
+	writeLCD(t, lcd, pins, []lcdWrite{{b: 0x28}, {b: 0x0c}})
+
+	// a data byte is executed after its low nibble
+	strobeNibble(t, lcd, pins, true, 'A'>>4)
+	if got := lcd.Lines()[0]; got != strings.Repeat(" ", 16) {
+		t.Errorf("after high nibble %q, want empty", got)
+	}
+	strobeNibble(t, lcd, pins, true, 'A'&0x0f)
+	if got := lcd.Lines()[0]; got != "A"+strings.Repeat(" ", 15) {
+		t.Errorf("after low nibble %q, want A", got)
+	}
+}
+
+func TestHD44780Addressing20x4(t *testing.T) {
+	tests := []struct {
+		address byte
+		col     int
+		row     int
+	}{
+		{0x00, 0, 0},
+		{0x13, 19, 0},
+		{0x40, 0, 1},
+		{0x53, 19, 1},
+		{0x14, 0, 2},
+		{0x27, 19, 2},
+		{0x54, 0, 3},
+		{0x67, 19, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%02x", tt.address), func(t *testing.T) {
+			lcd, pins := newTestLCD(t, 20, 4)
+			initLCD(t, lcd, pins)
+			writeLCD(t, lcd, pins, append([]lcdWrite{{b: 0x80 | tt.address}}, lcdText("X")...))
+			for row, line := range lcd.Lines() {
+				want := strings.Repeat(" ", 20)
+				if row == tt.row {
+					want = want[:tt.col] + "X" + want[tt.col+1:]
+				}
+				if line != want {
+					t.Errorf("row %d %q, want %q", row, line, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHD44780Wrap20x4(t *testing.T) {
+	lcd, pins := newTestLCD(t, 20, 4)
+	initLCD(t, lcd, pins)
+	// row 1 continues on row 3, and row 2 on row 4
+	text := strings.Repeat("a", 20) + "bbbb"
+	writeLCD(t, lcd, pins, lcdText(text))
+	want := []string{strings.Repeat("a", 20), strings.Repeat(" ", 20), "bbbb" + strings.Repeat(" ", 16), strings.Repeat(" ", 20)}
+	if got := lcd.Lines(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+	col, row, _ := lcd.Cursor()
+	if col != 4 || row != 2 {
+		t.Errorf("cursor at %d,%d, want 4,2", col, row)
+	}
+}
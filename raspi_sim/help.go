@@ -0,0 +1,154 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"gobot.io/x/gobot"
+	"gobot.io/x/gobot/platforms/keyboard"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SetHelpKey sets the key that prints the key bindings (defaults to '?').
+// Set to 0 to disable help
+func (sim *GobotSimulator) SetHelpKey(key rune) {
+	sim.helpKey = key
+}
+
+// SetOutput sets where the simulator prints help and status (defaults to stdout)
+func (sim *GobotSimulator) SetOutput(w io.Writer) {
+	sim.output = w
+}
+
+// EnableStatusLine continuously refreshes a status line with
+// the values of the watched pins while the simulator runs
+func (sim *GobotSimulator) EnableStatusLine(interval time.Duration) {
+	sim.statusInterval = interval
+}
+
+// WriteHelp writes a table of the key bindings with their pins,
+// GPIO numbers and current pin values. Virtual pins, like the inputs of
+// a 74HC165 chain, have no GPIO number
+func (sim *GobotSimulator) WriteHelp(w io.Writer) error {
+	keys := make([]rune, 0, len(sim.gpioKeymap))
+	for key := range sim.gpioKeymap {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tACTION\tPIN\tGPIO\tVALUE")
+	for _, key := range keys {
+		action := sim.gpioKeymap[key]
+		description := "custom"
+		if stringer, ok := action.(fmt.Stringer); ok {
+			description = stringer.String()
+		}
+		pins := action.Pins()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", keyName(key), description,
+			strings.Join(pins, ","), strings.Join(sim.gpioNumbers(pins), ","),
+			strings.Join(sim.pinValues(pins), ","))
+	}
+	if sim.helpKey != 0 {
+		fmt.Fprintf(tw, "%s\thelp\t\t\t\n", keyName(sim.helpKey))
+	}
+	if sim.consoleKey != 0 {
+		fmt.Fprintf(tw, "%s\tconsole\t\t\t\n", keyName(sim.consoleKey))
+	}
+	return tw.Flush()
+}
+
+// statusLine returns the values of the watched pins on a single line
+func (sim *GobotSimulator) statusLine() string {
+	var parts []string
+	for _, w := range sim.watchers() {
+		label := w.Pin()
+		if w.Name() != "" {
+			label = fmt.Sprintf("%s (%s)", w.Name(), w.Pin())
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", label, sim.pinValues([]string{w.Pin()})[0]))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// startStatusLine refreshes the status line in place on the output
+func (sim *GobotSimulator) startStatusLine() *time.Ticker {
+	return gobot.Every(sim.statusInterval, func() {
+		fmt.Fprintf(sim.output, "\r\033[K%s", sim.statusLine())
+	})
+}
+
+// gpioNumbers translates pins to gpio numbers
+func (sim *GobotSimulator) gpioNumbers(pins []string) []string {
+	numbers := make([]string, len(pins))
+	for i, pin := range pins {
+		gpio, err := sim.pinToGPIOMap.ToGPIO(pin)
+		if err != nil {
+			gpio = "-"
+		}
+		numbers[i] = gpio
+	}
+	return numbers
+}
+
+// pinValues reads the current values of pins
+func (sim *GobotSimulator) pinValues(pins []string) []string {
+	values := make([]string, len(pins))
+	for i, pin := range pins {
+		v, err := sim.readPinOrInput(pin)
+		if err != nil {
+			values[i] = "?"
+			continue
+		}
+		values[i] = strconv.Itoa(v)
+	}
+	return values
+}
+
+// readPinOrInput reads a pin, or the virtual input pin of a 74HC165 chain
+func (sim *GobotSimulator) readPinOrInput(pin string) (int, error) {
+	for _, r := range sim.inputChains {
+		if _, found := r.names[pin]; found {
+			return r.pinRead(pin)
+		}
+	}
+	return sim.pinRead(pin)
+}
+
+// keyName returns a readable name for a key
+func keyName(key rune) string {
+	switch key {
+	case KeyArrowUp:
+		return "up"
+	case KeyArrowDown:
+		return "down"
+	case KeyArrowLeft:
+		return "left"
+	case KeyArrowRight:
+		return "right"
+	case ' ':
+		return "space"
+	}
+	return string(key)
+}
+
+// eventKey returns the key of a keyboard event. The keyboard driver only
+// recognizes some keys, for other single byte keys (like '?' or 'A') the byte is used.
+// Of the escape sequences only the arrow keys are supported
+func eventKey(ev keyboard.KeyEvent) rune {
+	if ev.Bytes[1] != 0 || ev.Bytes[2] != 0 {
+		if ev.Bytes[0] == keyboard.Escape {
+			return arrowKeys[ev.Key]
+		}
+		return 0
+	}
+	if ev.Key != 0 {
+		return rune(ev.Key)
+	}
+	return rune(ev.Bytes[0])
+}
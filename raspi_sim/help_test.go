@@ -0,0 +1,44 @@
+package raspi_sim
+
+import (
+	"bytes"
+	"github.com/24hoursmedia/gobot-sim"
+	"strings"
+	"testing"
+)
+
+func TestWriteHelp(t *testing.T) {
+	sim := newTestSimulator()
+	r, err := sim.AddHC165("11", "13", "15", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetInput(3, gobot_sim.PIN_ON); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AddHC165Key('b', r, 3, gobot_sim.PW_ACTION_TOGGLE); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.EnableConsole(':'); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := sim.WriteHelp(&out); err != nil {
+		t.Fatal(err)
+	}
+	rows := map[string][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		rows[fields[0]] = fields
+	}
+	if got := strings.Join(rows["b"], " "); !strings.HasSuffix(got, "165@11:D3 - 1") {
+		t.Errorf("input row %q, want the virtual pin, no gpio and value 1", got)
+	}
+	if got := strings.Join(rows[":"], " "); got != ": console" {
+		t.Errorf("console row %q, want \": console\"", got)
+	}
+	if got := strings.Join(rows["?"], " "); got != "? help" {
+		t.Errorf("help row %q, want \"? help\"", got)
+	}
+}
@@ -0,0 +1,259 @@
+package raspi_sim
+
+import (
+	"errors"
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/24hoursmedia/gobot-sim/hybrid_sysfs"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// KeypadLayout4x4 is the layout of a common 4x4 membrane keypad
+var KeypadLayout4x4 = [][]rune{
+	{'1', '2', '3', 'A'},
+	{'4', '5', '6', 'B'},
+	{'7', '8', '9', 'C'},
+	{'*', '0', '#', 'D'},
+}
+
+// MatrixKeypad simulates a matrix keypad that is read by row scanning.
+// The application drives the row pins to the scan value one at a time and reads
+// the column pins. A column reads the scan value if the key in the driven row
+// and that column is pressed, otherwise it is pulled to the opposite value.
+// Rows that the application has not written yet are not driven.
+type MatrixKeypad struct {
+	rowPins []string
+	colPins []string
+	layout  [][]rune
+
+	scanValue     byte
+	pressDuration time.Duration
+	pressed       map[rune]time.Time
+	driven        []bool
+	mutex         *sync.Mutex
+
+	pinRead gobot_sim.PinReadFunc
+}
+
+// NewMatrixKeypad creates a keypad with a layout of len(rowPins) rows of len(colPins) keys.
+// By default the keypad is scanned active low (columns pulled up, the scanned row driven low).
+func NewMatrixKeypad(rowPins []string, colPins []string, layout [][]rune, pinRead gobot_sim.PinReadFunc) (*MatrixKeypad, error) {
+	if len(layout) != len(rowPins) {
+		return nil, fmt.Errorf("keypad layout has %d rows, expected %d", len(layout), len(rowPins))
+	}
+	for _, row := range layout {
+		if len(row) != len(colPins) {
+			return nil, fmt.Errorf("keypad layout row has %d keys, expected %d", len(row), len(colPins))
+		}
+	}
+	return &MatrixKeypad{
+		rowPins:       rowPins,
+		colPins:       colPins,
+		layout:        layout,
+		scanValue:     gobot_sim.PIN_OFF,
+		pressDuration: time.Millisecond * 100,
+		pressed:       make(map[rune]time.Time),
+		driven:        make([]bool, len(rowPins)),
+		mutex:         &sync.Mutex{},
+		pinRead:       pinRead,
+	}, nil
+}
+
+// Pins returns the row pins followed by the column pins
+func (k *MatrixKeypad) Pins() []string {
+	return append(append([]string{}, k.rowPins...), k.colPins...)
+}
+
+// SetScanValue sets the value the application drives a row to when scanning it.
+// Use PIN_OFF for active low scanning (default), PIN_ON for active high
+func (k *MatrixKeypad) SetScanValue(v byte) {
+	k.scanValue = v
+}
+
+// SetPressDuration sets how long a key stays pressed after Press.
+// Pressing it again while it is pressed extends the press, so a held
+// key that auto repeats stays pressed.
+func (k *MatrixKeypad) SetPressDuration(d time.Duration) {
+	k.pressDuration = d
+}
+
+// Press presses a key of the keypad
+func (k *MatrixKeypad) Press(key rune) error {
+	if _, _, found := k.position(key); !found {
+		return fmt.Errorf("key %s is not on the keypad", strconv.QuoteRune(key))
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.pressed[key] = time.Now().Add(k.pressDuration)
+	return nil
+}
+
+// Release releases a pressed key
+func (k *MatrixKeypad) Release(key rune) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	delete(k.pressed, key)
+}
+
+// IsPressed returns whether a key is currently pressed
+func (k *MatrixKeypad) IsPressed(key rune) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return time.Now().Before(k.pressed[key])
+}
+
+// position returns the row and column of a key
+func (k *MatrixKeypad) position(key rune) (int, int, bool) {
+	for r, row := range k.layout {
+		for c, label := range row {
+			if label == key {
+				return r, c, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// rowWriteHook returns the write hook for the value file of a row pin,
+// which marks the row as driven
+func (k *MatrixKeypad) rowWriteHook(row int) func(v int) {
+	return func(v int) {
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+
+		k.driven[row] = true
+	}
+}
+
+// isDriven returns whether the application has driven a row
+func (k *MatrixKeypad) isDriven(row int) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.driven[row]
+}
+
+// columnValue determines the value of a column pin from the
+// driven rows and the pressed keys
+func (k *MatrixKeypad) columnValue(col int) byte {
+	idle := gobot_sim.PIN_ON
+	if k.scanValue == gobot_sim.PIN_ON {
+		idle = gobot_sim.PIN_OFF
+	}
+	for r, row := range k.layout {
+		if !k.isDriven(r) || !k.IsPressed(row[col]) {
+			continue
+		}
+		v, err := k.pinRead(k.rowPins[r])
+		if err != nil {
+			log.Err(err).Str("pin", k.rowPins[r]).Msg("keypad - reading row")
+			continue
+		}
+		if byte(v) == k.scanValue {
+			return k.scanValue
+		}
+	}
+	return byte(idle)
+}
+
+// columnReadHook returns the read hook for the value file of a column pin
+func (k *MatrixKeypad) columnReadHook(col int) hybrid_sysfs.ReadHookFunc {
+	return func(contents string) string {
+		return strconv.Itoa(int(k.columnValue(col)))
+	}
+}
+
+// KeyAction returns an action that presses a key of the keypad
+func (k *MatrixKeypad) KeyAction(key rune) *KeypadKeyAction {
+	return &KeypadKeyAction{keypad: k, key: key}
+}
+
+var _ gobot_sim.Action = (*KeypadKeyAction)(nil)
+
+// KeypadKeyAction presses a key of a keypad, so it can be mapped to a key
+type KeypadKeyAction struct {
+	keypad *MatrixKeypad
+	key    rune
+}
+
+// Key returns the keypad key that is pressed
+func (ac *KeypadKeyAction) Key() rune {
+	return ac.key
+}
+
+// String describes the action
+func (ac *KeypadKeyAction) String() string {
+	return "keypad key " + strconv.QuoteRune(ac.key)
+}
+
+// Pins returns the pins of the keypad
+func (ac *KeypadKeyAction) Pins() []string {
+	return ac.keypad.Pins()
+}
+
+// Execute presses the keypad key
+func (ac *KeypadKeyAction) Execute() error {
+	return ac.keypad.Press(ac.key)
+}
+
+// AddMatrixKeypad simulates a matrix keypad on the row and column pins.
+// Keypad keys that can be typed on the keyboard are mapped to the same key,
+// with uppercase letters mapped to lowercase. Other keys, like '#', can be mapped
+// with AddKeypadKey.
+func (sim *GobotSimulator) AddMatrixKeypad(rowPins []string, colPins []string, layout [][]rune) (*MatrixKeypad, error) {
+	kp, err := NewMatrixKeypad(rowPins, colPins, layout, sim.pinRead)
+	if err != nil {
+		return nil, err
+	}
+	for i, pin := range rowPins {
+		if err := sim.addWriteHook(pin, kp.rowWriteHook(i)); err != nil {
+			return nil, err
+		}
+	}
+	for i, pin := range colPins {
+		if err := sim.setReadHook(pin, kp.columnReadHook(i)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, row := range layout {
+		for _, key := range row {
+			pcKey := unicode.ToLower(key)
+			if !typeable(pcKey) {
+				continue
+			}
+			if err := sim.AddKeypadKey(pcKey, kp, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return kp, nil
+}
+
+// AddKeypadKey maps a key press to pressing a key of a keypad
+func (sim *GobotSimulator) AddKeypadKey(pcKey rune, kp *MatrixKeypad, key rune) error {
+	if _, _, found := kp.position(key); !found {
+		return errors.New("key is not on the keypad")
+	}
+	return sim.AddKeyPressAction(pcKey, kp.KeyAction(key))
+}
+
+// typeable returns whether the keyboard driver reports a key
+func typeable(key rune) bool {
+	switch {
+	case key >= '0' && key <= '9', key >= 'a' && key <= 'z':
+		return true
+	}
+	switch key {
+	case ' ', '-', '*', '+', '/', '.':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"testing"
+)
+
+func TestMatrixKeypadColumns(t *testing.T) {
+	// rows r1 and r2, columns c1 and c2, the scanned row is driven low
+	layout := [][]rune{{'1', '2'}, {'3', '4'}}
+	tests := []struct {
+		name    string
+		written map[int]int
+		want    string
+	}{
+		{"no rows written", map[int]int{}, "11"},
+		{"pressed row scanned", map[int]int{0: gobot_sim.PIN_OFF, 1: gobot_sim.PIN_ON}, "01"},
+		{"other row scanned", map[int]int{0: gobot_sim.PIN_ON, 1: gobot_sim.PIN_OFF}, "11"},
+		{"other row written", map[int]int{1: gobot_sim.PIN_ON}, "11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// unwritten pins read low, like the row of the pressed key
+			pins := stubPins{}
+			kp, err := NewMatrixKeypad([]string{"r1", "r2"}, []string{"c1", "c2"}, layout, pins.read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for row, v := range tt.written {
+				pins[kp.rowPins[row]] = v
+				kp.rowWriteHook(row)(v)
+			}
+			if err := kp.Press('1'); err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			for col := range kp.colPins {
+				got += kp.columnReadHook(col)("")
+			}
+			if got != tt.want {
+				t.Errorf("columns %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package raspi_sim
 
 import (
+	"context"
 	"fmt"
 	"github.com/24hoursmedia/gobot-sim"
 	"github.com/24hoursmedia/gobot-sim/hybrid_sysfs"
@@ -9,7 +10,12 @@ import (
 	"gobot.io/x/gobot/drivers/gpio"
 	"gobot.io/x/gobot/platforms/keyboard"
 	"gobot.io/x/gobot/sysfs"
+	"io"
+	"math/rand"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,14 +25,64 @@ type RaspiAdaptor interface {
 	gobot.Adaptor
 }
 
+// Keys for the arrow keys. They are private use runes, so they cannot
+// clash with the characters of other keys
+const (
+	KeyArrowUp rune = 0xe000 + iota
+	KeyArrowDown
+	KeyArrowRight
+	KeyArrowLeft
+)
+
+// arrowKeys translates the arrow keys reported by the keyboard driver
+var arrowKeys = map[int]rune{
+	keyboard.ArrowUp:    KeyArrowUp,
+	keyboard.ArrowDown:  KeyArrowDown,
+	keyboard.ArrowRight: KeyArrowRight,
+	keyboard.ArrowLeft:  KeyArrowLeft,
+}
+
 type GobotSimulator struct {
 	name          string
 	adapter       RaspiAdaptor
 	pinToGPIOMap  *PinToGPIOMap
-	gpioKeymap    map[rune]*gobot_sim.PinWriteAction
+	gpioKeymap    map[rune]gobot_sim.Action
 	gpioWatchers  []*gobot_sim.PinWatcher
 	watchInterval time.Duration
 	usedGPIOPins  map[string]bool
+	readHooks     map[string]hybrid_sysfs.ReadHookFunc
+	writeHooks    map[string][]func(v int)
+	floatingPins  map[string]bool
+	pinConfigs    map[string]gobot_sim.PinConfig
+	noise         *rand.Rand
+	noiseMutex    *sync.Mutex
+	actionQueue   *gobot_sim.ActionQueue
+	robot         *gobot.Robot
+	tickers       []*time.Ticker
+	cancelPolling context.CancelFunc
+	mutex         *sync.Mutex
+
+	helpKey        rune
+	output         io.Writer
+	statusInterval time.Duration
+
+	consoleKey      rune
+	consoleActive   bool
+	consoleLine     []rune
+	consoleWatchers map[string]*gobot_sim.PinWatcher
+	consoleMutex    *sync.Mutex
+
+	chaos            *ChaosGenerator
+	signalGenerators []*SignalGenerator
+	distanceSensors  []*HCSR04
+	inputChains      []*HC165
+	displays         []*SevenSegmentDisplay
+
+	subscriptions        map[<-chan gobot_sim.PinChangedEvent]*subscription
+	subscriptionBuffer   int
+	subscriptionOverflow int
+
+	errors *errorPolicy
 }
 
 // NewGobotSimulator creates a bot that makes your machine
@@ -35,10 +91,26 @@ func NewGobotSimulator(adapter RaspiAdaptor) *GobotSimulator {
 	sim := &GobotSimulator{}
 	sim.name = "GobotSim"
 	sim.pinToGPIOMap = RPI3PinGPIOMap
-	sim.gpioKeymap = map[rune]*gobot_sim.PinWriteAction{}
+	sim.gpioKeymap = map[rune]gobot_sim.Action{}
 	sim.adapter = adapter
 	sim.watchInterval = time.Millisecond * 20
 	sim.usedGPIOPins = make(map[string]bool)
+	sim.readHooks = make(map[string]hybrid_sysfs.ReadHookFunc)
+	sim.writeHooks = make(map[string][]func(v int))
+	sim.floatingPins = make(map[string]bool)
+	sim.pinConfigs = make(map[string]gobot_sim.PinConfig)
+	sim.noise = rand.New(rand.NewSource(1))
+	sim.noiseMutex = &sync.Mutex{}
+	sim.mutex = &sync.Mutex{}
+	sim.helpKey = '?'
+	sim.output = os.Stdout
+	sim.subscriptions = make(map[<-chan gobot_sim.PinChangedEvent]*subscription)
+	sim.subscriptionBuffer = DefaultSubscriptionBuffer
+	sim.actionQueue = gobot_sim.NewActionQueue(16)
+	sim.errors = &errorPolicy{counts: make(map[string]int), mutex: &sync.Mutex{}}
+	sim.actionQueue.SetErrorFunc(func(action gobot_sim.Action, err error) {
+		sim.handleError("action", action.Pins(), err)
+	})
 	log.Debug().Str("name", sim.name).Msg("Created new gobot-sim")
 	return sim
 }
@@ -48,6 +120,74 @@ func (sim *GobotSimulator) SetPinToGPIOMap(pinToGPIO *PinToGPIOMap) {
 	sim.pinToGPIOMap = pinToGPIO
 }
 
+// SetWatchInterval sets how often watchers are polled when they need polling
+// and have no interval of their own (defaults to 20ms)
+func (sim *GobotSimulator) SetWatchInterval(d time.Duration) {
+	sim.watchInterval = d
+}
+
+// SetNoiseSeed seeds the random generator that determines what
+// floating pins read, so runs are reproducible (defaults to 1)
+func (sim *GobotSimulator) SetNoiseSeed(seed int64) {
+	sim.noiseMutex.Lock()
+	defer sim.noiseMutex.Unlock()
+
+	sim.noise = rand.New(rand.NewSource(seed))
+}
+
+// ConfigurePin sets how a pin is wired, i.e. active low with a pull up resistor.
+// Pins start at their inactive level, and actions and watchers that are added
+// for the pin after configuring it use its on and off values.
+// A floating input (an input without pull resistor) that is not driven to
+// its on value reads random values.
+func (sim *GobotSimulator) ConfigurePin(pin string, config gobot_sim.PinConfig) error {
+	log.Debug().Str("pin", pin).Bool("active_low", config.ActiveLow).Int("pull", config.Pull).
+		Msg("Configuring pin")
+	if sim.running() {
+		return fmt.Errorf("pin %s must be configured before the simulator runs", pin)
+	}
+	usePinErr := sim.usePinForGPIO(pin)
+	if usePinErr != nil {
+		return usePinErr
+	}
+	gpioPin, _ := sim.pinToGPIOMap.ToGPIO(pin)
+	if _, hooked := sim.readHooks[gpioPin]; hooked && !sim.floatingPins[gpioPin] {
+		return fmt.Errorf("pin %s is read through a device, its wiring cannot be configured", pin)
+	}
+	sim.pinConfigs[pin] = config
+
+	delete(sim.readHooks, gpioPin)
+	delete(sim.floatingPins, gpioPin)
+	if !config.Floating() {
+		return nil
+	}
+	if err := sim.setReadHook(pin, sim.floatingReadHook(config)); err != nil {
+		return err
+	}
+	sim.floatingPins[gpioPin] = true
+	return nil
+}
+
+// pinConfig returns the configuration of a pin, which defaults to
+// an active high pin without pull resistor
+func (sim *GobotSimulator) pinConfig(pin string) gobot_sim.PinConfig {
+	return sim.pinConfigs[pin]
+}
+
+// floatingReadHook returns a read hook that reads noise unless
+// a floating pin is driven to its on value
+func (sim *GobotSimulator) floatingReadHook(config gobot_sim.PinConfig) hybrid_sysfs.ReadHookFunc {
+	on := strconv.Itoa(int(config.OnValue()))
+	return func(contents string) string {
+		if contents == on {
+			return contents
+		}
+		sim.noiseMutex.Lock()
+		defer sim.noiseMutex.Unlock()
+		return strconv.Itoa(sim.noise.Intn(2))
+	}
+}
+
 // enterSimulationMode sets up the local machine and hooks into the file system
 // to intercept specific gpio pins. Note that these are GPIO pin numbers, not board pin numbers
 func (sim *GobotSimulator) enterSimulationMode() {
@@ -60,14 +200,28 @@ func (sim *GobotSimulator) enterSimulationMode() {
 	for gpioPinNum, _ := range sim.usedGPIOPins {
 		log.Debug().Str("gpio", gpioPinNum).Msg("entersim - hooking into GPIO")
 		fs.AddMockablePath(fmt.Sprintf("/sys/class/gpio/gpio%s/direction", gpioPinNum))
-		fs.AddMockablePath(fmt.Sprintf("/sys/class/gpio/gpio%s/value", gpioPinNum))
+		fs.AddMockablePath(gpioValuePath(gpioPinNum))
+		// like a real gpio value file, a pin reads low until written
+		fs.SetMockContents(gpioValuePath(gpioPinNum), strconv.Itoa(gobot_sim.PIN_OFF))
+	}
+	for pin, config := range sim.pinConfigs {
+		gpioPinNum, _ := sim.pinToGPIOMap.ToGPIO(pin)
+		fs.SetMockContents(gpioValuePath(gpioPinNum), strconv.Itoa(int(config.OffValue())))
+	}
+	for gpioPinNum, hook := range sim.readHooks {
+		fs.SetReadHook(gpioValuePath(gpioPinNum), hook)
+	}
+	for gpioPinNum, _ := range sim.usedGPIOPins {
+		fs.SetWriteHook(gpioValuePath(gpioPinNum), sim.valueWriteHook(gpioPinNum))
 	}
 	sysfs.SetFilesystem(fs)
 	sysfs.SetSyscall(&hybrid_sysfs.HybridSyscall{})
 }
 
 // usePinForGPIO tells the simulator to use a pin for GPIO
-// this runs the pin through the simulator instead of the HW board
+// this runs the pin through the simulator instead of the HW board.
+// Pins are intercepted when the simulator starts running, so after that
+// only pins that are already intercepted can be used
 func (sim *GobotSimulator) usePinForGPIO(pin string) error {
 	// translate pin to gpio num and map it so we know it is used
 	gpioPin, pinErr := sim.pinToGPIOMap.ToGPIO(pin)
@@ -76,29 +230,211 @@ func (sim *GobotSimulator) usePinForGPIO(pin string) error {
 		return pinErr
 	}
 
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	if sim.robot != nil && !sim.usedGPIOPins[gpioPin] {
+		return fmt.Errorf("pin %s is not simulated, it must be used before the simulator runs", pin)
+	}
 	sim.usedGPIOPins[gpioPin] = true
 	return nil
 }
 
+// intercepted returns whether a gpio pin runs through the simulator
+func (sim *GobotSimulator) intercepted(gpioPin string) bool {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	return sim.usedGPIOPins[gpioPin]
+}
+
+// running returns whether the simulator runs
+func (sim *GobotSimulator) running() bool {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	return sim.robot != nil
+}
+
+// setReadHook sets a function that determines the value of a pin
+// each time it is read, instead of the value last written to it
+func (sim *GobotSimulator) setReadHook(pin string, hook hybrid_sysfs.ReadHookFunc) error {
+	if sim.running() {
+		return fmt.Errorf("pin %s is read through a device, which must be added before the simulator runs", pin)
+	}
+	usePinErr := sim.usePinForGPIO(pin)
+	if usePinErr != nil {
+		return usePinErr
+	}
+	gpioPin, _ := sim.pinToGPIOMap.ToGPIO(pin)
+	sim.readHooks[gpioPin] = hook
+	// a device replaces the noise of a floating pin
+	delete(sim.floatingPins, gpioPin)
+	return nil
+}
+
+// addWriteHook adds a function that is called with the value
+// each time a pin is written, so devices can follow the writes of the application
+func (sim *GobotSimulator) addWriteHook(pin string, hook func(v int)) error {
+	if sim.running() {
+		return fmt.Errorf("pin %s is written to a device, which must be added before the simulator runs", pin)
+	}
+	usePinErr := sim.usePinForGPIO(pin)
+	if usePinErr != nil {
+		return usePinErr
+	}
+	gpioPin, _ := sim.pinToGPIOMap.ToGPIO(pin)
+	sim.writeHooks[gpioPin] = append(sim.writeHooks[gpioPin], hook)
+	return nil
+}
+
+// valueWriteHook notifies the write hooks and the watchers of a gpio pin whenever its value file is written,
+// so every change is detected when it happens, no matter how short a pulse is.
+// Pins with a read hook are read instead, because the hook determines their value.
+func (sim *GobotSimulator) valueWriteHook(gpioPinNum string) hybrid_sysfs.WriteHookFunc {
+	_, hooked := sim.readHooks[gpioPinNum]
+	writeHooks := sim.writeHooks[gpioPinNum]
+	return func(contents string) {
+		v, err := strconv.Atoi(strings.TrimSpace(contents))
+		if err != nil {
+			log.Err(err).Str("gpio", gpioPinNum).Msg("write hook - invalid value")
+			return
+		}
+		for _, hook := range writeHooks {
+			hook(v)
+		}
+		for _, w := range sim.watchers() {
+			if gpio, _ := sim.pinToGPIOMap.ToGPIO(w.Pin()); gpio != gpioPinNum {
+				continue
+			}
+			if w.Mode() == gobot_sim.WATCH_POLL {
+				continue
+			}
+			if hooked {
+				sim.observe(w)
+			} else {
+				sim.observeValue(w, v)
+			}
+		}
+	}
+}
+
+// polled returns whether a watcher must be polled: when asked for with its mode
+// or interval, when the value of its pin is determined by a read hook instead
+// of by writes, or to report values that settled after a debounce or minimum pulse width
+func (sim *GobotSimulator) polled(w *gobot_sim.PinWatcher) bool {
+	switch w.Mode() {
+	case gobot_sim.WATCH_POLL:
+		return true
+	case gobot_sim.WATCH_EVENTS:
+		return false
+	}
+	if w.Interval() > 0 || w.Debounce() > 0 || w.MinPulseWidth() > 0 {
+		return true
+	}
+	gpioPin, _ := sim.pinToGPIOMap.ToGPIO(w.Pin())
+	_, hooked := sim.readHooks[gpioPin]
+	return hooked
+}
+
+// gpioValuePath returns the path of the sysfs value file of a gpio pin
+func gpioValuePath(gpioPinNum string) string {
+	return fmt.Sprintf("/sys/class/gpio/gpio%s/value", gpioPinNum)
+}
+
+// AddKeyPressAction executes an action when a key is pressed.
+// The pins of the action are intercepted by the simulator.
+func (sim *GobotSimulator) AddKeyPressAction(key rune, action gobot_sim.Action) error {
+	for _, pin := range action.Pins() {
+		usePinErr := sim.usePinForGPIO(pin)
+		if usePinErr != nil {
+			return usePinErr
+		}
+	}
+	sim.mapKey(key, action)
+	return nil
+}
+
+// mapKey maps a key to an action, without intercepting its pins
+func (sim *GobotSimulator) mapKey(key rune, action gobot_sim.Action) {
+	log.Debug().Str("key", strconv.QuoteRune(key)).Strs("pins", action.Pins()).
+		Msg("Mapping key")
+
+	sim.gpioKeymap[key] = action
+}
+
+// PinFuncs returns the functions to read and write pins through the simulator,
+// for use in custom actions
+func (sim *GobotSimulator) PinFuncs() *gobot_sim.PinFuncs {
+	return &gobot_sim.PinFuncs{Write: sim.pinWrite, Read: sim.pinRead}
+}
+
 // AddKeyPressPWAction writes something to a pin when a key is pressed.
 // It maps a key press to a specific action on a pin, for example
 // to turn it on or simulate a button press and release
 func (sim *GobotSimulator) AddKeyPressPWAction(key rune, pin string, action int) (*gobot_sim.PinWriteAction, error) {
-	log.Debug().Str("pin", pin).Msg("entersim - hooking pin into GPIO")
-	log.Debug().Str("key", strconv.QuoteRune(key)).Str("pin", pin).
-		Msg("Mapping key")
+	ac := gobot_sim.NewPinWriteAction(pin, action, sim.PinFuncs())
+	config := sim.pinConfig(pin)
+	ac.SetOnOffValues(config.OnValue(), config.OffValue())
+	if err := sim.AddKeyPressAction(key, ac); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
 
-	usePinErr := sim.usePinForGPIO(pin)
-	if usePinErr != nil {
-		return nil, usePinErr
+// AddKeyPressSequenceAction writes a waveform to a pin when a key is pressed.
+// The waveform is a sequence of steps, i.e. a double click or a long press
+func (sim *GobotSimulator) AddKeyPressSequenceAction(key rune, pin string, steps []gobot_sim.SequenceStep) (*gobot_sim.PinSequenceAction, error) {
+	ac := gobot_sim.NewPinSequenceAction(pin, steps, sim.PinFuncs())
+	config := sim.pinConfig(pin)
+	ac.SetOnOffValues(config.OnValue(), config.OffValue())
+	if err := sim.AddKeyPressAction(key, ac); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// AddKeyPressPatternAction is like AddKeyPressSequenceAction but takes a
+// compact pattern string, i.e. "(1:100ms 0:100ms)x3 1:1s 0".
+// See gobot_sim.ParseSequencePattern for the syntax
+func (sim *GobotSimulator) AddKeyPressPatternAction(key rune, pin string, pattern string) (*gobot_sim.PinSequenceAction, error) {
+	steps, err := gobot_sim.ParseSequencePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return sim.AddKeyPressSequenceAction(key, pin, steps)
+}
+
+// AddRotaryEncoder simulates a quadrature rotary encoder on pins A (CLK) and B (DT).
+// Pressing cwKey turns it one step clockwise, ccwKey one step counter clockwise.
+// The arrow keys are convenient, i.e. KeyArrowRight and KeyArrowLeft.
+// Both pins must be configured with the same on and off values
+func (sim *GobotSimulator) AddRotaryEncoder(cwKey rune, ccwKey rune, pinA string, pinB string) (*gobot_sim.RotaryEncoder, error) {
+	configA, configB := sim.pinConfig(pinA), sim.pinConfig(pinB)
+	if configA.OnValue() != configB.OnValue() || configA.OffValue() != configB.OffValue() {
+		return nil, fmt.Errorf("pins %s and %s of a rotary encoder must have the same on and off values", pinA, pinB)
+	}
+	enc := gobot_sim.NewRotaryEncoder(pinA, pinB, sim.PinFuncs())
+	enc.SetOnOffValues(configA.OnValue(), configA.OffValue())
+	if err := sim.AddKeyPressAction(cwKey, enc.CWAction()); err != nil {
+		return nil, err
+	}
+	if err := sim.AddKeyPressAction(ccwKey, enc.CCWAction()); err != nil {
+		return nil, err
 	}
+	return enc, nil
+}
 
-	pinFuncs := &gobot_sim.PinFuncs{Write: sim.pinWrite, Read: sim.pinRead}
-	sim.gpioKeymap[key] = gobot_sim.NewPinWriteAction(pin, action, pinFuncs)
-	return sim.gpioKeymap[key], nil
+// AddRotaryEncoderSwitch adds a push switch on a pin to a rotary encoder,
+// which is pressed and released when a key is pressed
+func (sim *GobotSimulator) AddRotaryEncoderSwitch(key rune, enc *gobot_sim.RotaryEncoder, pin string) error {
+	enc.SetSwitchPin(pin)
+	config := sim.pinConfig(pin)
+	enc.SwitchAction().SetOnOffValues(config.OnValue(), config.OffValue())
+	return sim.AddKeyPressAction(key, enc.PressAction())
 }
 
-// WatchPin intercepts writes to a pin and calls a function if the value changed
+// WatchPin intercepts writes to a pin and calls a function if the value changed.
+// After Run only pins that are already simulated can be watched
 func (sim *GobotSimulator) WatchPin(pin string, handler gobot_sim.PinChangedFunc) (*gobot_sim.PinWatcher, error) {
 	log.Debug().Msgf("add watcher for pin %s", pin)
 
@@ -109,10 +445,55 @@ func (sim *GobotSimulator) WatchPin(pin string, handler gobot_sim.PinChangedFunc
 
 	watchFuncs := &gobot_sim.WatchFuncs{Read: sim.pinRead, Changed: handler}
 	watcher := gobot_sim.NewPinWatcher(pin, watchFuncs)
+	config := sim.pinConfig(pin)
+	watcher.SetOnOffValues(config.OnValue(), config.OffValue())
+	sim.mutex.Lock()
 	sim.gpioWatchers = append(sim.gpioWatchers, watcher)
+	sim.mutex.Unlock()
+	if sim.running() {
+		// the current value is needed to detect the first change
+		sim.observe(watcher)
+	}
+	return watcher, nil
+}
+
+// WatchPinEdges adds a watcher that only reports rising or falling edges of a pin,
+// with edges gobot_sim.EDGE_RISING, gobot_sim.EDGE_FALLING or gobot_sim.EDGE_BOTH
+func (sim *GobotSimulator) WatchPinEdges(pin string, edges int, handler gobot_sim.PinChangedFunc) (*gobot_sim.PinWatcher, error) {
+	watcher, err := sim.WatchPin(pin, handler)
+	if err != nil {
+		return nil, err
+	}
+	watcher.SetEdges(edges)
 	return watcher, nil
 }
 
+// MeasurePin measures the frequency, duty cycle and pulse durations of a pin.
+// handler, if not nil, is called with the statistics after each period,
+// and the statistics can be queried from the returned meter
+func (sim *GobotSimulator) MeasurePin(pin string, handler gobot_sim.PulseStatsFunc) (*gobot_sim.PulseMeter, error) {
+	meter := gobot_sim.NewPulseMeter(pin, handler)
+	watcher, err := sim.WatchPin(pin, meter.Changed)
+	if err != nil {
+		return nil, err
+	}
+	watcher.SetName("pulse meter")
+	return meter, nil
+}
+
+// UnwatchPin removes a watcher
+func (sim *GobotSimulator) UnwatchPin(watcher *gobot_sim.PinWatcher) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	for i, w := range sim.gpioWatchers {
+		if w == watcher {
+			sim.gpioWatchers = append(sim.gpioWatchers[:i], sim.gpioWatchers[i+1:]...)
+			return
+		}
+	}
+}
+
 // pinWrite is the handler passed to PinWrite/ReadActions so it has access to the local context
 func (sim *GobotSimulator) pinWrite(pin string, v byte) error {
 	return sim.adapter.DigitalWrite(pin, v)
@@ -126,53 +507,170 @@ func (sim *GobotSimulator) pinRead(pin string) (int, error) {
 // Run sets up the simulator bot and starts it
 func (sim *GobotSimulator) Run() error {
 	sim.enterSimulationMode()
+	sim.mutex.Lock()
+	sim.robot = sim.newRobot()
+	sim.mutex.Unlock()
 	go sim.goRun()
 	return nil
 }
 
-func Stop() error {
+// Stop stops the simulator. Actions that are executing are aborted
+func (sim *GobotSimulator) Stop() error {
+	if sim.chaos != nil {
+		sim.chaos.Stop()
+	}
+	for _, gen := range sim.signalGenerators {
+		gen.Stop()
+	}
+	for _, sensor := range sim.distanceSensors {
+		sensor.Stop()
+	}
+	sim.actionQueue.Stop()
+
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	for _, ticker := range sim.tickers {
+		ticker.Stop()
+	}
+	if sim.cancelPolling != nil {
+		sim.cancelPolling()
+	}
+	if sim.robot != nil && sim.robot.Running() {
+		return sim.robot.Stop()
+	}
 	return nil
 }
 
 // goRun is the go routine for running
 func (sim *GobotSimulator) goRun() error {
+	log.Info().
+		Int("num_pin_watchers", len(sim.gpioWatchers)).
+		Int("num_keypress_watchers", len(sim.gpioKeymap)).
+		Msg("Simulator ready")
+	sim.robot.Start()
+	return nil
+}
+
+// poll observes the watchers that must be polled, each at its own interval,
+// until the context is done. Intervals and watchers that change
+// are picked up within the default watch interval.
+func (sim *GobotSimulator) poll(ctx context.Context) {
+	due := make(map[*gobot_sim.PinWatcher]time.Time)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		now := time.Now()
+		next := now.Add(sim.watchInterval)
+		active := make(map[*gobot_sim.PinWatcher]time.Time)
+		for _, w := range sim.watchers() {
+			if !sim.polled(w) {
+				continue
+			}
+			at, found := due[w]
+			if !found || !now.Before(at) {
+				sim.observe(w)
+				interval := w.Interval()
+				if interval <= 0 {
+					interval = sim.watchInterval
+				}
+				at = now.Add(interval)
+			}
+			active[w] = at
+			if at.Before(next) {
+				next = at
+			}
+		}
+		due = active
+		timer.Reset(time.Until(next))
+	}
+}
+
+// addTicker registers a ticker that is stopped when the simulator stops
+func (sim *GobotSimulator) addTicker(ticker *time.Ticker) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	sim.tickers = append(sim.tickers, ticker)
+}
+
+// watchers returns the registered pin watchers
+func (sim *GobotSimulator) watchers() []*gobot_sim.PinWatcher {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	return append([]*gobot_sim.PinWatcher{}, sim.gpioWatchers...)
+}
+
+// newRobot creates the robot that handles the keyboard and the watchers
+func (sim *GobotSimulator) newRobot() *gobot.Robot {
 	keys := keyboard.NewDriver()
 	work := func() {
-		if len(sim.gpioKeymap) > 0 {
-			log.Debug().Int("count", len(sim.gpioKeymap)).Msg("Setup keypress handlers")
-			keys.On(keyboard.Key, func(data interface{}) {
-				key := data.(keyboard.KeyEvent)
-				if action, ok := sim.gpioKeymap[rune(key.Key)]; ok {
-					log.Debug().Str("key", strconv.QuoteRune(rune(key.Key))).Str("pin", action.Pin()).
-						Int("action", action.Action()).Msg("Key pressed")
-					err := action.Execute()
-					if err != nil {
-						log.Err(err).Msg("")
-					}
+		log.Debug().Int("count", len(sim.gpioKeymap)).Msg("Setup keypress handlers")
+		keys.On(keyboard.Key, func(data interface{}) {
+			ev := data.(keyboard.KeyEvent)
+			if sim.consoleActive {
+				sim.consoleInput(ev)
+				return
+			}
+			key := eventKey(ev)
+			if key == sim.consoleKey && key != 0 {
+				sim.openConsolePrompt()
+				return
+			}
+			if key == sim.helpKey && key != 0 {
+				sim.WriteHelp(sim.output)
+				return
+			}
+			if action, ok := sim.gpioKeymap[key]; ok {
+				log.Debug().Str("key", strconv.QuoteRune(key)).Strs("pins", action.Pins()).
+					Msg("Key pressed")
+				if err := sim.actionQueue.Enqueue(action); err != nil {
+					sim.handleError("action", action.Pins(), err)
 				}
-			})
+			}
+		})
+		log.Info().Msg("Setup watchers")
+		for _, w := range sim.watchers() {
+			sim.observe(w)
 		}
-		if len(sim.gpioWatchers) > 0 {
-			log.Info().Msg("Setup watchers")
-			gobot.Every(sim.watchInterval, func() {
-				for _, w := range sim.gpioWatchers {
-					w.Observe()
-				}
-			})
+		sim.mutex.Lock()
+		displays := append([]*SevenSegmentDisplay{}, sim.displays...)
+		sim.mutex.Unlock()
+		for _, display := range displays {
+			if err := display.sync(); err != nil {
+				sim.handleError("watcher", display.Pins(), err)
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sim.mutex.Lock()
+		sim.cancelPolling = cancel
+		sim.mutex.Unlock()
+		go sim.poll(ctx)
+		if sim.statusInterval > 0 {
+			sim.addTicker(sim.startStatusLine())
+		}
+		if sim.chaos != nil {
+			sim.chaos.start()
+		}
+		for _, gen := range sim.signalGenerators {
+			gen.start()
+		}
+		for _, sensor := range sim.distanceSensors {
+			sensor.start()
 		}
 	}
 
-	robot := gobot.NewRobot(sim.name,
+	return gobot.NewRobot(sim.name,
 		[]gobot.Connection{},
 		[]gobot.Device{keys},
 		work,
 	)
-	log.Info().
-		Int("num_pin_watchers", len(sim.gpioWatchers)).
-		Int("num_keypress_watchers", len(sim.gpioKeymap)).
-		Msg("Simulator ready")
-	robot.Start()
-	return nil
 }
 
 func Close() error {
@@ -0,0 +1,40 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"testing"
+)
+
+func TestAddRotaryEncoderConfig(t *testing.T) {
+	pullUp := gobot_sim.PinConfig{ActiveLow: true, Pull: gobot_sim.PULL_UP, Input: true}
+	tests := []struct {
+		name    string
+		configA *gobot_sim.PinConfig
+		configB *gobot_sim.PinConfig
+		valid   bool
+	}{
+		{"not configured", nil, nil, true},
+		{"configured alike", &pullUp, &pullUp, true},
+		{"only pin A configured", &pullUp, nil, false},
+		{"only pin B configured", nil, &pullUp, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newTestSimulator()
+			if tt.configA != nil {
+				if err := sim.ConfigurePin("11", *tt.configA); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.configB != nil {
+				if err := sim.ConfigurePin("13", *tt.configB); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := sim.AddRotaryEncoder(KeyArrowRight, KeyArrowLeft, "11", "13")
+			if valid := err == nil; valid != tt.valid {
+				t.Errorf("error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
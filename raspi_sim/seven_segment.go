@@ -0,0 +1,334 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segments of a digit, as bits of a segment mask
+const (
+	SEGMENT_A = 1 << iota
+	SEGMENT_B
+	SEGMENT_C
+	SEGMENT_D
+	SEGMENT_E
+	SEGMENT_F
+	SEGMENT_G
+	SEGMENT_DP
+)
+
+// DefaultPersistence is how long a lit segment stays visible, like the persistence of vision
+const DefaultPersistence = time.Millisecond * 20
+
+// DefaultVisibleDuty is the default fraction of time a segment must be lit to be visible
+const DefaultVisibleDuty = 0.05
+
+// sevenSegmentChars are the characters of segment masks, without the decimal point
+var sevenSegmentChars = map[byte]rune{
+	0x00: ' ',
+	0x3f: '0', 0x06: '1', 0x5b: '2', 0x4f: '3', 0x66: '4',
+	0x6d: '5', 0x7d: '6', 0x07: '7', 0x7f: '8', 0x6f: '9',
+	0x77: 'A', 0x7c: 'b', 0x39: 'C', 0x5e: 'd', 0x79: 'E', 0x71: 'F',
+	0x3d: 'G', 0x76: 'H', 0x1e: 'J', 0x38: 'L', 0x54: 'n', 0x5c: 'o',
+	0x73: 'P', 0x50: 'r', 0x78: 't', 0x3e: 'U', 0x6e: 'y',
+	0x40: '-', 0x08: '_', 0x27: '7', 0x67: '9',
+}
+
+// SevenSegmentDisplay is a virtual multiplexed 7 segment led display. The segment
+// pins are shared by all digits, and the digit pins select which digits light up.
+// The display integrates the multiplexed writes over time like the eye does,
+// so a digit is visible when it is lit often enough.
+type SevenSegmentDisplay struct {
+	segmentPins []string
+	digitPins   []string
+	segmentOn   byte
+	digitOn     byte
+	persistence time.Duration
+	visibleDuty float64
+
+	segments   byte
+	digits     []bool
+	brightness [][8]float64
+	integrated time.Time
+
+	output   io.Writer
+	rendered string
+	pinRead  gobot_sim.PinReadFunc
+	mutex    *sync.Mutex
+}
+
+// NewSevenSegmentDisplay creates a display with segment pins a-g and optionally dp,
+// and a select pin for each digit from left to right. By default segments are lit
+// by writing PIN_ON and digits are selected by writing PIN_OFF, like a common
+// cathode display that is wired directly
+func NewSevenSegmentDisplay(segmentPins []string, digitPins []string) (*SevenSegmentDisplay, error) {
+	if len(segmentPins) != 7 && len(segmentPins) != 8 {
+		return nil, fmt.Errorf("a 7 segment display has 7 or 8 segment pins, not %d", len(segmentPins))
+	}
+	if len(digitPins) == 0 {
+		return nil, fmt.Errorf("a 7 segment display needs at least one digit pin")
+	}
+	return &SevenSegmentDisplay{
+		segmentPins: segmentPins,
+		digitPins:   digitPins,
+		segmentOn:   gobot_sim.PIN_ON,
+		digitOn:     gobot_sim.PIN_OFF,
+		persistence: DefaultPersistence,
+		visibleDuty: DefaultVisibleDuty,
+		digits:      make([]bool, len(digitPins)),
+		brightness:  make([][8]float64, len(digitPins)),
+		mutex:       &sync.Mutex{},
+	}, nil
+}
+
+// Pins returns the segment and digit pins
+func (d *SevenSegmentDisplay) Pins() []string {
+	return append(append([]string{}, d.segmentPins...), d.digitPins...)
+}
+
+// SetOnValues sets the values that light a segment and select a digit,
+// i.e. PIN_OFF and PIN_ON for a common anode display
+func (d *SevenSegmentDisplay) SetOnValues(segmentOn byte, digitOn byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.segmentOn = segmentOn
+	d.digitOn = digitOn
+}
+
+// SetPersistence sets how long a lit segment stays visible (defaults to 20ms)
+func (d *SevenSegmentDisplay) SetPersistence(persistence time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.persistence = persistence
+}
+
+// SetVisibleDuty sets the fraction of time a segment must be lit to be visible (defaults to 0.05)
+func (d *SevenSegmentDisplay) SetVisibleDuty(duty float64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.visibleDuty = duty
+}
+
+// SetOutput renders the display to w whenever the visible digits change, nil disables rendering
+func (d *SevenSegmentDisplay) SetOutput(w io.Writer) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.output = w
+}
+
+// Brightness returns the fraction of time a segment of a digit was lit recently,
+// with segment one of SEGMENT_A to SEGMENT_DP
+func (d *SevenSegmentDisplay) Brightness(digit int, segment byte) float64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.integrate(time.Now())
+	for i := 0; i < 8; i++ {
+		if segment == 1<<uint(i) {
+			return d.brightness[digit][i]
+		}
+	}
+	return 0
+}
+
+// Segments returns the mask of the visible segments of a digit
+func (d *SevenSegmentDisplay) Segments(digit int) byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.integrate(time.Now())
+	return d.visibleSegments(digit)
+}
+
+// Text returns the visible digits, with a '.' after a digit with a lit decimal
+// point and '?' for segments that do not form a character
+func (d *SevenSegmentDisplay) Text() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.integrate(time.Now())
+	return d.text()
+}
+
+// Render draws the visible digits with 3 lines of text
+func (d *SevenSegmentDisplay) Render(w io.Writer) error {
+	d.mutex.Lock()
+	d.integrate(time.Now())
+	drawing := d.draw()
+	d.mutex.Unlock()
+
+	_, err := io.WriteString(w, drawing)
+	return err
+}
+
+// changed integrates the display up to a change of a pin and applies the change
+func (d *SevenSegmentDisplay) changed(ev gobot_sim.PinChangedEvent) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.integrate(ev.Time)
+	for i, pin := range d.segmentPins {
+		if pin == ev.Pin {
+			if ev.Value == int(d.segmentOn) {
+				d.segments |= 1 << uint(i)
+			} else {
+				d.segments &^= 1 << uint(i)
+			}
+		}
+	}
+	for i, pin := range d.digitPins {
+		if pin == ev.Pin {
+			d.digits[i] = ev.Value == int(d.digitOn)
+		}
+	}
+	d.render()
+	return nil
+}
+
+// sync sets the segments and digits from the current values of the pins,
+// because a pin that is written the value it already has does not change
+func (d *SevenSegmentDisplay) sync() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pinRead == nil {
+		return nil
+	}
+	d.integrate(time.Now())
+	for i, pin := range d.segmentPins {
+		v, err := d.pinRead(pin)
+		if err != nil {
+			return err
+		}
+		if v == int(d.segmentOn) {
+			d.segments |= 1 << uint(i)
+		} else {
+			d.segments &^= 1 << uint(i)
+		}
+	}
+	for i, pin := range d.digitPins {
+		v, err := d.pinRead(pin)
+		if err != nil {
+			return err
+		}
+		d.digits[i] = v == int(d.digitOn)
+	}
+	d.render()
+	return nil
+}
+
+// integrate updates the brightness of all segments up to a time, as a first order
+// low pass filter of whether they are lit with the persistence as time constant
+func (d *SevenSegmentDisplay) integrate(now time.Time) {
+	if !now.After(d.integrated) {
+		return
+	}
+	decay := math.Exp(-float64(now.Sub(d.integrated)) / float64(d.persistence))
+	d.integrated = now
+	for digit, selected := range d.digits {
+		for i := 0; i < 8; i++ {
+			lit := 0.0
+			if selected && d.segments&(1<<uint(i)) != 0 {
+				lit = 1
+			}
+			d.brightness[digit][i] = d.brightness[digit][i]*decay + lit*(1-decay)
+		}
+	}
+}
+
+// visibleSegments returns the mask of the visible segments of a digit
+func (d *SevenSegmentDisplay) visibleSegments(digit int) byte {
+	var mask byte
+	for i := 0; i < 8; i++ {
+		if d.brightness[digit][i] >= d.visibleDuty {
+			mask |= 1 << uint(i)
+		}
+	}
+	return mask
+}
+
+// text returns the visible digits
+func (d *SevenSegmentDisplay) text() string {
+	var b strings.Builder
+	for digit := range d.digitPins {
+		mask := d.visibleSegments(digit)
+		char, found := sevenSegmentChars[mask&^SEGMENT_DP]
+		if !found {
+			char = '?'
+		}
+		b.WriteRune(char)
+		if mask&SEGMENT_DP != 0 {
+			b.WriteRune('.')
+		}
+	}
+	return b.String()
+}
+
+// draw draws the visible digits with 3 lines of text
+func (d *SevenSegmentDisplay) draw() string {
+	var lines [3]strings.Builder
+	for digit := range d.digitPins {
+		mask := d.visibleSegments(digit)
+		segment := func(s byte, on string) string {
+			if mask&s != 0 {
+				return on
+			}
+			return " "
+		}
+		lines[0].WriteString(" " + segment(SEGMENT_A, "_") + "  ")
+		lines[1].WriteString(segment(SEGMENT_F, "|") + segment(SEGMENT_G, "_") + segment(SEGMENT_B, "|") + " ")
+		lines[2].WriteString(segment(SEGMENT_E, "|") + segment(SEGMENT_D, "_") + segment(SEGMENT_C, "|") + segment(SEGMENT_DP, "."))
+	}
+	return lines[0].String() + "\n" + lines[1].String() + "\n" + lines[2].String() + "\n"
+}
+
+// render renders the display to the output if the visible digits changed
+func (d *SevenSegmentDisplay) render() {
+	if d.output == nil {
+		return
+	}
+	drawing := d.draw()
+	if drawing == d.rendered {
+		return
+	}
+	d.rendered = drawing
+	io.WriteString(d.output, drawing)
+}
+
+// AddSevenSegmentDisplay adds a virtual multiplexed 7 segment display,
+// see NewSevenSegmentDisplay
+func (sim *GobotSimulator) AddSevenSegmentDisplay(segmentPins []string, digitPins []string) (*SevenSegmentDisplay, error) {
+	display, err := NewSevenSegmentDisplay(segmentPins, digitPins)
+	if err != nil {
+		return nil, err
+	}
+	display.pinRead = sim.pinRead
+	for _, pin := range display.Pins() {
+		w, err := sim.WatchPin(pin, display.changed)
+		if err != nil {
+			return nil, err
+		}
+		// every write must be integrated at the time it happens
+		w.SetMode(gobot_sim.WATCH_EVENTS)
+		w.SetName("7 segment display")
+	}
+	sim.mutex.Lock()
+	sim.displays = append(sim.displays, display)
+	running := sim.robot != nil
+	sim.mutex.Unlock()
+	if running {
+		if err := display.sync(); err != nil {
+			return nil, err
+		}
+	}
+	return display, nil
+}
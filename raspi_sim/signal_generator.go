@@ -0,0 +1,325 @@
+package raspi_sim
+
+import (
+	"context"
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/rs/zerolog/log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	SIGNAL_SQUARE = iota
+	SIGNAL_PULSE
+	SIGNAL_TACHOMETER
+)
+
+// MaxSignalFrequency is the highest frequency in Hz of a signal generator,
+// each period takes at least two writes to the pin
+const MaxSignalFrequency = 100000.0
+
+// SignalGenerator drives an input pin with a periodic signal, like the pulse
+// train of a flow meter, fan tachometer or anemometer.
+// The rate can be changed at runtime, from code or with keys.
+type SignalGenerator struct {
+	pin  string
+	kind int
+
+	frequency    float64
+	dutyCycle    float64
+	pulseWidth   time.Duration
+	pulsesPerRev int
+
+	onValue  byte
+	offValue byte
+
+	changed chan struct{}
+	mutex   *sync.Mutex
+	cancel  context.CancelFunc
+
+	pinFuncs *gobot_sim.PinFuncs
+	onError  func(pin string, err error)
+}
+
+// AddSquareWave drives a pin with a square wave of a frequency in Hz and a duty cycle of 50%
+func (sim *GobotSimulator) AddSquareWave(pin string, frequency float64) (*SignalGenerator, error) {
+	return sim.addSignalGenerator(pin, SIGNAL_SQUARE, frequency, 0, 0)
+}
+
+// AddPulseTrain drives a pin with pulses of a fixed width, at a rate in pulses per second
+func (sim *GobotSimulator) AddPulseTrain(pin string, rate float64, width time.Duration) (*SignalGenerator, error) {
+	return sim.addSignalGenerator(pin, SIGNAL_PULSE, rate, width, 0)
+}
+
+// AddTachometer drives a pin with the pulses of a tachometer that gives
+// pulsesPerRev pulses for each revolution, i.e. 2 for most PC fans
+func (sim *GobotSimulator) AddTachometer(pin string, rpm float64, pulsesPerRev int) (*SignalGenerator, error) {
+	if pulsesPerRev < 1 {
+		return nil, fmt.Errorf("invalid number of pulses per revolution %d", pulsesPerRev)
+	}
+	return sim.addSignalGenerator(pin, SIGNAL_TACHOMETER, rpm/60*float64(pulsesPerRev), 0, pulsesPerRev)
+}
+
+// addSignalGenerator creates a generator that starts when the simulator runs
+func (sim *GobotSimulator) addSignalGenerator(pin string, kind int, frequency float64, pulseWidth time.Duration, pulsesPerRev int) (*SignalGenerator, error) {
+	if err := sim.usePinForGPIO(pin); err != nil {
+		return nil, err
+	}
+	config := sim.pinConfig(pin)
+	gen := &SignalGenerator{
+		pin:          pin,
+		kind:         kind,
+		dutyCycle:    0.5,
+		pulseWidth:   pulseWidth,
+		pulsesPerRev: pulsesPerRev,
+		onValue:      config.OnValue(),
+		offValue:     config.OffValue(),
+		changed:      make(chan struct{}, 1),
+		mutex:        &sync.Mutex{},
+		pinFuncs:     sim.PinFuncs(),
+		onError: func(pin string, err error) {
+			sim.handleError("signal generator", []string{pin}, err)
+		},
+	}
+	if err := gen.SetFrequency(frequency); err != nil {
+		return nil, err
+	}
+	sim.signalGenerators = append(sim.signalGenerators, gen)
+	return gen, nil
+}
+
+// Pin returns the pin the generator drives
+func (g *SignalGenerator) Pin() string {
+	return g.pin
+}
+
+// SetFrequency sets the frequency of the signal in Hz, 0 stops the signal.
+// The frequency can be up to MaxSignalFrequency
+func (g *SignalGenerator) SetFrequency(frequency float64) error {
+	if frequency < 0 || frequency > MaxSignalFrequency || math.IsNaN(frequency) {
+		return fmt.Errorf("invalid frequency %f", frequency)
+	}
+	g.mutex.Lock()
+	g.frequency = frequency
+	g.mutex.Unlock()
+
+	g.notify()
+	return nil
+}
+
+// Frequency returns the frequency of the signal in Hz
+func (g *SignalGenerator) Frequency() float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.frequency
+}
+
+// SetRPM sets the revolutions per minute of a tachometer
+func (g *SignalGenerator) SetRPM(rpm float64) error {
+	if g.kind != SIGNAL_TACHOMETER {
+		return fmt.Errorf("signal generator on pin %s is not a tachometer", g.pin)
+	}
+	return g.SetFrequency(rpm / 60 * float64(g.pulsesPerRev))
+}
+
+// RPM returns the revolutions per minute of a tachometer
+func (g *SignalGenerator) RPM() float64 {
+	if g.kind != SIGNAL_TACHOMETER {
+		return 0
+	}
+	return g.Frequency() * 60 / float64(g.pulsesPerRev)
+}
+
+// SetDutyCycle sets the fraction of the period the signal is on,
+// for square waves and tachometers (defaults to 0.5)
+func (g *SignalGenerator) SetDutyCycle(dutyCycle float64) error {
+	if dutyCycle < 0 || dutyCycle > 1 {
+		return fmt.Errorf("invalid duty cycle %f", dutyCycle)
+	}
+	g.mutex.Lock()
+	g.dutyCycle = dutyCycle
+	g.mutex.Unlock()
+
+	g.notify()
+	return nil
+}
+
+// Adjust changes the rate of the signal by delta: the frequency in Hz,
+// or for a tachometer the revolutions per minute. The rate does not go below 0
+func (g *SignalGenerator) Adjust(delta float64) error {
+	if g.kind == SIGNAL_TACHOMETER {
+		return g.SetRPM(max0(g.RPM() + delta))
+	}
+	return g.SetFrequency(max0(g.Frequency() + delta))
+}
+
+// AdjustAction returns an action that changes the rate of the signal by delta,
+// so the rate can be changed with keys
+func (g *SignalGenerator) AdjustAction(delta float64) *SignalAdjustAction {
+	return &SignalAdjustAction{generator: g, delta: delta}
+}
+
+// times returns the on and off time of a period, or false if the signal is stopped
+func (g *SignalGenerator) times() (time.Duration, time.Duration, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.frequency <= 0 {
+		return 0, 0, false
+	}
+	period := time.Duration(float64(time.Second) / g.frequency)
+	on := time.Duration(float64(period) * g.dutyCycle)
+	if g.kind == SIGNAL_PULSE {
+		on = g.pulseWidth
+		if on > period {
+			on = period
+		}
+	}
+	return on, period - on, true
+}
+
+// notify wakes up the generator after a change
+func (g *SignalGenerator) notify() {
+	select {
+	case g.changed <- struct{}{}:
+	default:
+	}
+}
+
+// start generates the signal until the generator is stopped
+func (g *SignalGenerator) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.mutex.Lock()
+	g.cancel = cancel
+	g.mutex.Unlock()
+
+	go g.run(ctx)
+}
+
+// Stop stops generating the signal
+func (g *SignalGenerator) Stop() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.cancel != nil {
+		g.cancel()
+	}
+}
+
+// run writes the periods of the signal. Edges are scheduled from the previous
+// edge instead of the current time, so the signal does not drift. A change
+// reschedules the end of the current half period with the new times
+func (g *SignalGenerator) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	edge := time.Now()
+	for ctx.Err() == nil {
+		on, off, running := g.times()
+		if !running {
+			g.write(g.offValue)
+			select {
+			case <-ctx.Done():
+				return
+			case <-g.changed:
+				edge = time.Now()
+				continue
+			}
+		}
+		for half := 0; half < 2 && running; half++ {
+			value, duration := g.onValue, on
+			if half == 1 {
+				value, duration = g.offValue, off
+			}
+			if duration <= 0 {
+				continue
+			}
+			g.write(value)
+			start := edge
+			edge = start.Add(duration)
+			timer.Reset(time.Until(edge))
+			for waiting := true; waiting; {
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+					waiting = false
+				case <-g.changed:
+					if !timer.Stop() {
+						<-timer.C
+					}
+					on, off, running = g.times()
+					if !running {
+						waiting = false
+						continue
+					}
+					duration = on
+					if half == 1 {
+						duration = off
+					}
+					edge = start.Add(duration)
+					if now := time.Now(); edge.Before(now) {
+						// i.e. a much higher frequency, the half period is over
+						edge = now
+					}
+					timer.Reset(time.Until(edge))
+				}
+			}
+		}
+		if time.Since(edge) > time.Second {
+			// fell behind, i.e. after a very high frequency
+			edge = time.Now()
+		}
+	}
+}
+
+// write writes a value to the pin
+func (g *SignalGenerator) write(v byte) {
+	if err := g.pinFuncs.Write(g.pin, v); err != nil {
+		g.onError(g.pin, err)
+	}
+}
+
+// max0 returns v, or 0 if v is negative
+func max0(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+var _ gobot_sim.Action = (*SignalAdjustAction)(nil)
+
+// SignalAdjustAction changes the rate of a signal generator, so it can be mapped to a key
+type SignalAdjustAction struct {
+	generator *SignalGenerator
+	delta     float64
+}
+
+// String describes the action
+func (ac *SignalAdjustAction) String() string {
+	unit := "Hz"
+	if ac.generator.kind == SIGNAL_TACHOMETER {
+		unit = "rpm"
+	}
+	return fmt.Sprintf("signal %+g %s", ac.delta, unit)
+}
+
+// Pins returns the pin of the generator
+func (ac *SignalAdjustAction) Pins() []string {
+	return []string{ac.generator.pin}
+}
+
+// Execute changes the rate of the signal
+func (ac *SignalAdjustAction) Execute() error {
+	if err := ac.generator.Adjust(ac.delta); err != nil {
+		return err
+	}
+	log.Info().Str("pin", ac.generator.pin).Float64("frequency", ac.generator.Frequency()).
+		Msg("signal generator - adjusted")
+	return nil
+}
@@ -0,0 +1,63 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignalGeneratorFrequency(t *testing.T) {
+	tests := []struct {
+		frequency float64
+		valid     bool
+	}{
+		{0, true},
+		{0.2, true},
+		{1000, true},
+		{MaxSignalFrequency, true},
+		{-1, false},
+		{MaxSignalFrequency * 2, false},
+		{1e10, false},
+		{math.Inf(1), false},
+		{math.NaN(), false},
+	}
+	sim := newTestSimulator()
+	gen, err := sim.AddSquareWave("11", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		err := gen.SetFrequency(tt.frequency)
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("frequency %g: error %v, want valid %v", tt.frequency, err, tt.valid)
+		}
+	}
+}
+
+func TestSignalGeneratorStop(t *testing.T) {
+	sim := newTestSimulator()
+	gen, err := sim.AddSquareWave("11", MaxSignalFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var writes int64
+	gen.pinFuncs = &gobot_sim.PinFuncs{Write: func(pin string, v byte) error {
+		atomic.AddInt64(&writes, 1)
+		return nil
+	}}
+	gen.start()
+	time.Sleep(time.Millisecond * 20)
+	gen.Stop()
+	time.Sleep(time.Millisecond * 20)
+
+	stopped := atomic.LoadInt64(&writes)
+	if stopped == 0 {
+		t.Fatal("no writes before stopping")
+	}
+	time.Sleep(time.Millisecond * 20)
+	if got := atomic.LoadInt64(&writes); got != stopped {
+		t.Errorf("%d writes after stopping", got-stopped)
+	}
+}
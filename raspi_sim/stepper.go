@@ -0,0 +1,290 @@
+package raspi_sim
+
+import (
+	"fmt"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// DefaultSpeedWindow is the time over which the speed of a stepper is measured
+const DefaultSpeedWindow = time.Millisecond * 250
+
+// stepperPhases are the half step phases of a 4 coil stepper, with a bit per coil.
+// Wave drive uses the even phases and full step (dual coil) drive the odd phases.
+var stepperPhases = map[byte]int{
+	0x01: 0, 0x03: 1, 0x02: 2, 0x06: 3,
+	0x04: 4, 0x0c: 5, 0x08: 6, 0x09: 7,
+}
+
+// StepperEvent is emitted when a stepper moves or skips
+type StepperEvent struct {
+	Position int
+	// Delta is the number of steps moved, negative when moving backward
+	Delta int
+	// Speed is in steps per second, negative when moving backward
+	Speed float64
+	// Skipped is set when steps were skipped, because the sequence was
+	// invalid or the steps were too fast
+	Skipped bool
+	Time    time.Time
+}
+
+// StepperFunc is called for each StepperEvent
+type StepperFunc func(ev StepperEvent) error
+
+// stepperSample is a position at a time, to measure speed
+type stepperSample struct {
+	at       time.Time
+	position int
+}
+
+// Stepper is a virtual stepper motor with a driver like a ULN2003, which energizes
+// 4 coils directly, or an A4988, which steps on the rising edge of a STEP pin in
+// the direction of a DIR pin. It tracks the position, speed and skipped steps.
+type Stepper struct {
+	pins       []string
+	halfSteps  bool
+	coils      byte
+	phase      int
+	halfPhases int
+
+	steps       int
+	skipped     int
+	maxSpeed    float64
+	lastStep    time.Time
+	samples     []stepperSample
+	speedWindow time.Duration
+
+	pinRead gobot_sim.PinReadFunc
+	handler StepperFunc
+	mutex   *sync.Mutex
+}
+
+// newStepper creates a stepper on pins
+func newStepper(pins []string, pinRead gobot_sim.PinReadFunc, handler StepperFunc) *Stepper {
+	return &Stepper{
+		pins:        pins,
+		phase:       -1,
+		speedWindow: DefaultSpeedWindow,
+		pinRead:     pinRead,
+		handler:     handler,
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// Pins returns the coil pins, or the STEP and DIR pins
+func (s *Stepper) Pins() []string {
+	return s.pins
+}
+
+// SetHalfStepping counts the position of a 4 coil stepper in half steps
+// instead of full steps
+func (s *Stepper) SetHalfStepping(halfSteps bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.halfSteps = halfSteps
+}
+
+// SetMaxSpeed sets the maximum speed in steps per second of a STEP/DIR stepper.
+// Faster steps are skipped, like a motor that stalls. 0 disables the limit
+func (s *Stepper) SetMaxSpeed(stepsPerSecond float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maxSpeed = stepsPerSecond
+}
+
+// SetSpeedWindow sets the time over which the speed is measured (defaults to 250ms)
+func (s *Stepper) SetSpeedWindow(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.speedWindow = d
+}
+
+// Position returns the position in steps from where the stepper started
+func (s *Stepper) Position() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.position()
+}
+
+// Speed returns the speed in steps per second, negative when moving backward
+func (s *Stepper) Speed() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.speed(time.Now())
+}
+
+// Skipped returns the number of times steps were skipped
+func (s *Stepper) Skipped() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.skipped
+}
+
+// coilChanged decodes the phase of the coils after a change of a coil pin.
+// Patterns that are not a phase, like 3 energized coils, are ignored because
+// they occur while the coil pins are written one by one.
+func (s *Stepper) coilChanged(ev gobot_sim.PinChangedEvent) error {
+	s.mutex.Lock()
+	for i, pin := range s.pins {
+		if pin == ev.Pin {
+			if ev.Value == gobot_sim.PIN_ON {
+				s.coils |= 1 << uint(i)
+			} else {
+				s.coils &^= 1 << uint(i)
+			}
+		}
+	}
+	phase, valid := stepperPhases[s.coils]
+	if !valid {
+		s.mutex.Unlock()
+		return nil
+	}
+	if s.phase < 0 {
+		s.phase = phase
+		s.mutex.Unlock()
+		return nil
+	}
+	delta := (phase - s.phase + 8) % 8
+	s.phase = phase
+	if delta > 4 {
+		delta -= 8
+	}
+	// a phase that is 3 or 4 half steps away cannot have been reached without
+	// skipping, and 4 half steps away the direction is unknown
+	skipped := delta == 4 || delta == -3 || delta == 3
+	if delta == 4 {
+		delta = 0
+	}
+	before := s.position()
+	s.halfPhases += delta
+	s.steps = s.halfPhases
+	if !s.halfSteps {
+		s.steps = floorDiv(s.halfPhases, 2)
+	}
+	return s.moved(ev.Time, s.position()-before, skipped)
+}
+
+// stepPulse steps in the direction of the DIR pin, on a rising edge of the STEP pin
+func (s *Stepper) stepPulse(ev gobot_sim.PinChangedEvent) error {
+	dir, err := s.pinRead(s.pins[1])
+	if err != nil {
+		return err
+	}
+	delta := 1
+	if dir != gobot_sim.PIN_ON {
+		delta = -1
+	}
+
+	s.mutex.Lock()
+	skipped := false
+	if s.maxSpeed > 0 && !s.lastStep.IsZero() &&
+		ev.Time.Sub(s.lastStep) < time.Duration(float64(time.Second)/s.maxSpeed) {
+		skipped = true
+		delta = 0
+	}
+	s.lastStep = ev.Time
+	s.steps += delta
+	return s.moved(ev.Time, delta, skipped)
+}
+
+// moved records a movement, unlocks the stepper and emits an event
+func (s *Stepper) moved(at time.Time, delta int, skipped bool) error {
+	if delta == 0 && !skipped {
+		s.mutex.Unlock()
+		return nil
+	}
+	if skipped {
+		s.skipped++
+	}
+	s.samples = append(s.samples, stepperSample{at: at, position: s.position()})
+	ev := StepperEvent{
+		Position: s.position(),
+		Delta:    delta,
+		Speed:    s.speed(at),
+		Skipped:  skipped,
+		Time:     at,
+	}
+	handler := s.handler
+	s.mutex.Unlock()
+
+	if skipped {
+		log.Warn().Strs("pins", s.pins).Int("position", ev.Position).Msg("stepper - skipped steps")
+	}
+	if handler != nil {
+		return handler(ev)
+	}
+	return nil
+}
+
+// position returns the position in steps
+func (s *Stepper) position() int {
+	return s.steps
+}
+
+// speed measures the speed over the speed window up to a time
+func (s *Stepper) speed(now time.Time) float64 {
+	start := now.Add(-s.speedWindow)
+	// keep the last sample before the window, it is the position at the start
+	for len(s.samples) > 1 && !s.samples[1].at.After(start) {
+		s.samples = s.samples[1:]
+	}
+	if len(s.samples) == 0 {
+		return 0
+	}
+	return float64(s.position()-s.samples[0].position) / s.speedWindow.Seconds()
+}
+
+// floorDiv divides rounding toward negative infinity
+func floorDiv(a int, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// AddCoilStepper adds a virtual stepper with 4 coils that are driven directly,
+// like a 28BYJ-48 with a ULN2003 driver. It decodes wave, full step and
+// half step sequences, with the coils in order on pins IN1-IN4.
+// handler, if not nil, is called whenever the stepper moves or skips.
+func (sim *GobotSimulator) AddCoilStepper(pins []string, handler StepperFunc) (*Stepper, error) {
+	if len(pins) != 4 {
+		return nil, fmt.Errorf("a coil stepper has 4 pins, not %d", len(pins))
+	}
+	s := newStepper(pins, sim.pinRead, handler)
+	for _, pin := range pins {
+		w, err := sim.WatchPin(pin, s.coilChanged)
+		if err != nil {
+			return nil, err
+		}
+		w.SetMode(gobot_sim.WATCH_EVENTS)
+		w.SetName("stepper")
+	}
+	return s, nil
+}
+
+// AddStepDirStepper adds a virtual stepper with a driver like an A4988, that makes
+// a step on the rising edge of the STEP pin. It moves forward when the DIR pin is on.
+// handler, if not nil, is called whenever the stepper moves or skips.
+func (sim *GobotSimulator) AddStepDirStepper(stepPin string, dirPin string, handler StepperFunc) (*Stepper, error) {
+	s := newStepper([]string{stepPin, dirPin}, sim.pinRead, handler)
+	if err := sim.usePinForGPIO(dirPin); err != nil {
+		return nil, err
+	}
+	w, err := sim.WatchPinEdges(stepPin, gobot_sim.EDGE_RISING, s.stepPulse)
+	if err != nil {
+		return nil, err
+	}
+	w.SetMode(gobot_sim.WATCH_EVENTS)
+	w.SetName("stepper")
+	return s, nil
+}
@@ -0,0 +1,134 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"testing"
+	"time"
+)
+
+var testCoilPins = []string{"in1", "in2", "in3", "in4"}
+
+// driveCoils writes each state of the coils, one pin at a time in order of the
+// pins like an application does, and returns the events of the stepper
+func driveCoils(t *testing.T, s *Stepper, states []byte) []StepperEvent {
+	var events []StepperEvent
+	s.handler = func(ev StepperEvent) error {
+		events = append(events, ev)
+		return nil
+	}
+	values := make([]int, len(testCoilPins))
+	at := time.Now()
+	for _, state := range states {
+		for i, pin := range testCoilPins {
+			v := int(state>>uint(i)) & 1
+			if v == values[i] {
+				continue
+			}
+			at = at.Add(time.Millisecond)
+			ev := gobot_sim.PinChangedEvent{Pin: pin, LastValue: values[i], Value: v, Time: at}
+			values[i] = v
+			if err := s.coilChanged(ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return events
+}
+
+func TestStepperCoils(t *testing.T) {
+	tests := []struct {
+		name      string
+		halfSteps bool
+		states    []byte
+		position  int
+		skipped   int
+	}{
+		{"wave forward", false, []byte{0x01, 0x02, 0x04, 0x08, 0x01}, 4, 0},
+		{"wave backward", false, []byte{0x01, 0x08, 0x04, 0x02, 0x01}, -4, 0},
+		{"full step forward", false, []byte{0x03, 0x06, 0x0c, 0x09, 0x03}, 4, 0},
+		{"full step backward", false, []byte{0x03, 0x09, 0x0c, 0x06}, -3, 0},
+		{"half step in full steps", false, []byte{0x01, 0x03, 0x02, 0x06, 0x04, 0x0c, 0x08, 0x09, 0x01}, 4, 0},
+		{"half step", true, []byte{0x01, 0x03, 0x02, 0x06, 0x04, 0x0c, 0x08, 0x09, 0x01}, 8, 0},
+		{"half step backward", true, []byte{0x01, 0x09, 0x08, 0x0c}, -3, 0},
+		{"reverse", false, []byte{0x01, 0x02, 0x04, 0x02, 0x01, 0x08}, -1, 0},
+		{"opposite coil skips", false, []byte{0x01, 0x04}, 0, 1},
+		{"opposite coil skips twice", false, []byte{0x01, 0x02, 0x08, 0x02}, 1, 2},
+		{"all off is ignored", false, []byte{0x01, 0x00, 0x02}, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStepper(testCoilPins, stubPins{}.read, nil)
+			s.SetHalfStepping(tt.halfSteps)
+			events := driveCoils(t, s, tt.states)
+			if got := s.Position(); got != tt.position {
+				t.Errorf("position %d, want %d", got, tt.position)
+			}
+			if got := s.Skipped(); got != tt.skipped {
+				t.Errorf("skipped %d, want %d", got, tt.skipped)
+			}
+			if len(events) > 0 && events[len(events)-1].Position != tt.position {
+				t.Errorf("last event at %d, want %d", events[len(events)-1].Position, tt.position)
+			}
+		})
+	}
+}
+
+func TestStepperStepDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		dirs     []int
+		interval time.Duration
+		maxSpeed float64
+		position int
+		skipped  int
+	}{
+		{"forward", []int{1, 1, 1}, time.Millisecond * 10, 0, 3, 0},
+		{"backward", []int{0, 0, 0}, time.Millisecond * 10, 0, -3, 0},
+		{"change direction", []int{1, 1, 0, 1, 1}, time.Millisecond * 10, 0, 3, 0},
+		{"within max speed", []int{1, 1, 1}, time.Millisecond * 30, 50, 3, 0},
+		{"too fast", []int{1, 1, 1}, time.Millisecond * 10, 50, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins := stubPins{}
+			s := newStepper([]string{"step", "dir"}, pins.read, nil)
+			s.SetMaxSpeed(tt.maxSpeed)
+			at := time.Now()
+			for _, dir := range tt.dirs {
+				pins["dir"] = dir
+				at = at.Add(tt.interval)
+				ev := gobot_sim.PinChangedEvent{Pin: "step", LastValue: 0, Value: 1, Time: at}
+				if err := s.stepPulse(ev); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := s.Position(); got != tt.position {
+				t.Errorf("position %d, want %d", got, tt.position)
+			}
+			if got := s.Skipped(); got != tt.skipped {
+				t.Errorf("skipped %d, want %d", got, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestStepperSpeed(t *testing.T) {
+	s := newStepper([]string{"step", "dir"}, stubPins{"dir": 1}.read, nil)
+	s.SetSpeedWindow(time.Second)
+	var speed float64
+	s.handler = func(ev StepperEvent) error {
+		speed = ev.Speed
+		return nil
+	}
+	at := time.Now()
+	// 10 steps per second for 2 seconds
+	for i := 0; i < 20; i++ {
+		at = at.Add(time.Millisecond * 100)
+		if err := s.stepPulse(gobot_sim.PinChangedEvent{Pin: "step", Value: 1, Time: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if speed != 10 {
+		t.Errorf("speed %g, want 10", speed)
+	}
+}
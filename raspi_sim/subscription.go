@@ -0,0 +1,151 @@
+package raspi_sim
+
+import (
+	"errors"
+	"github.com/24hoursmedia/gobot-sim"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// what happens when the buffer of a subscription is full
+const (
+	OVERFLOW_DROP_NEWEST = iota
+	OVERFLOW_DROP_OLDEST
+	OVERFLOW_BLOCK
+)
+
+// DefaultSubscriptionBuffer is the default buffer size of a subscription
+const DefaultSubscriptionBuffer = 64
+
+// subscription delivers the changes of pins to a channel
+type subscription struct {
+	events   chan gobot_sim.PinChangedEvent
+	watchers []*gobot_sim.PinWatcher
+	overflow int
+	dropped  int
+	closed   bool
+	done     chan struct{}
+	mutex    *sync.Mutex
+}
+
+// SetSubscriptionBuffer sets the buffer size and overflow policy of subscriptions
+// that are created after it: OVERFLOW_DROP_NEWEST (the default) drops events that do
+// not fit, OVERFLOW_DROP_OLDEST drops the oldest buffered event and OVERFLOW_BLOCK
+// blocks whoever writes the pin until there is room
+func (sim *GobotSimulator) SetSubscriptionBuffer(size int, overflow int) {
+	sim.subscriptionBuffer = size
+	sim.subscriptionOverflow = overflow
+}
+
+// Subscribe returns a channel that receives the changes of pins,
+// so they can be used in a select. Call Unsubscribe when done.
+// After Run only pins that are already simulated can be subscribed to.
+func (sim *GobotSimulator) Subscribe(pins ...string) (<-chan gobot_sim.PinChangedEvent, error) {
+	if len(pins) == 0 {
+		return nil, errors.New("subscribe: no pins")
+	}
+	sub := &subscription{
+		events:   make(chan gobot_sim.PinChangedEvent, sim.subscriptionBuffer),
+		overflow: sim.subscriptionOverflow,
+		done:     make(chan struct{}),
+		mutex:    &sync.Mutex{},
+	}
+	for _, pin := range pins {
+		w, err := sim.WatchPin(pin, sub.deliver)
+		if err != nil {
+			sub.unwatch(sim)
+			return nil, err
+		}
+		w.SetName("subscription")
+		sub.watchers = append(sub.watchers, w)
+	}
+
+	sim.mutex.Lock()
+	sim.subscriptions[sub.events] = sub
+	sim.mutex.Unlock()
+	return sub.events, nil
+}
+
+// Unsubscribe stops delivering events to a channel returned by Subscribe,
+// and closes the channel
+func (sim *GobotSimulator) Unsubscribe(events <-chan gobot_sim.PinChangedEvent) {
+	sim.mutex.Lock()
+	sub, found := sim.subscriptions[events]
+	delete(sim.subscriptions, events)
+	sim.mutex.Unlock()
+	if !found {
+		return
+	}
+	sub.unwatch(sim)
+	sub.close()
+}
+
+// DroppedEvents returns the number of events that did not fit in
+// the buffer of a channel returned by Subscribe
+func (sim *GobotSimulator) DroppedEvents(events <-chan gobot_sim.PinChangedEvent) int {
+	sim.mutex.Lock()
+	sub, found := sim.subscriptions[events]
+	sim.mutex.Unlock()
+	if !found {
+		return 0
+	}
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	return sub.dropped
+}
+
+// deliver sends an event to the channel according to the overflow policy
+func (sub *subscription) deliver(ev gobot_sim.PinChangedEvent) error {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	if sub.closed {
+		return nil
+	}
+	if sub.overflow == OVERFLOW_BLOCK {
+		select {
+		case sub.events <- ev:
+		case <-sub.done:
+		}
+		return nil
+	}
+	if sub.overflow == OVERFLOW_DROP_OLDEST && cap(sub.events) > 0 {
+		for {
+			select {
+			case sub.events <- ev:
+				return nil
+			default:
+			}
+			select {
+			case <-sub.events:
+				sub.dropped++
+			default:
+			}
+		}
+	}
+	select {
+	case sub.events <- ev:
+	default:
+		sub.dropped++
+		log.Warn().Str("pin", ev.Pin).Int("dropped", sub.dropped).Msg("subscription - buffer full")
+	}
+	return nil
+}
+
+// unwatch removes the watchers of the subscription
+func (sub *subscription) unwatch(sim *GobotSimulator) {
+	for _, w := range sub.watchers {
+		sim.UnwatchPin(w)
+	}
+}
+
+// close closes the channel, after releasing a blocked delivery
+func (sub *subscription) close() {
+	close(sub.done)
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	sub.closed = true
+	close(sub.events)
+}
@@ -0,0 +1,51 @@
+package raspi_sim
+
+import (
+	"github.com/24hoursmedia/gobot-sim"
+	"testing"
+	"time"
+)
+
+// runTestSimulator intercepts the pins of a simulator like Run does,
+// without starting the keyboard
+func runTestSimulator(sim *GobotSimulator) {
+	sim.enterSimulationMode()
+	sim.mutex.Lock()
+	sim.robot = sim.newRobot()
+	sim.mutex.Unlock()
+}
+
+func TestSubscribeAfterRun(t *testing.T) {
+	sim := newTestSimulator()
+	if _, err := sim.AddKeyPressPWAction('1', "11", gobot_sim.PW_ACTION_TOGGLE); err != nil {
+		t.Fatal(err)
+	}
+	runTestSimulator(sim)
+
+	if _, err := sim.Subscribe("13"); err == nil {
+		t.Error("subscribed to pin 13, which is not simulated")
+	}
+	if _, err := sim.WatchPin("13", nil); err == nil {
+		t.Error("watched pin 13, which is not simulated")
+	}
+	if err := sim.consolePin("13"); err == nil {
+		t.Error("pin 13 can be used in the console")
+	}
+
+	events, err := sim.Subscribe("11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Unsubscribe(events)
+	if err := sim.pinWrite("11", gobot_sim.PIN_ON); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		if ev.Pin != "11" || ev.Value != gobot_sim.PIN_ON {
+			t.Errorf("event %+v, want pin 11 on", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("no event for pin 11")
+	}
+}
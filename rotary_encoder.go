@@ -0,0 +1,193 @@
+package gobot_sim
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// quadrature is the Gray code sequence of (A, B) on values for one
+// clockwise detent. Counter clockwise runs through it in reverse.
+var quadrature = [4][2]bool{
+	{false, false},
+	{true, false},
+	{true, true},
+	{false, true},
+}
+
+// RotaryEncoder simulates a KY-040 style quadrature encoder on two pins.
+// Each step (detent) runs through a full Gray code cycle, after which both
+// pins rest at their off value again. The encoder can have a push switch.
+type RotaryEncoder struct {
+	pinA         string
+	pinB         string
+	switchAction *PinWriteAction
+
+	onValue  byte
+	offValue byte
+
+	transitionDelay time.Duration
+	position        int
+	mutex           *sync.Mutex
+
+	pinFuncs *PinFuncs
+}
+
+// NewRotaryEncoder creates a rotary encoder on the A (CLK) and B (DT) pins
+func NewRotaryEncoder(pinA string, pinB string, pinFuncs *PinFuncs) *RotaryEncoder {
+	return &RotaryEncoder{
+		pinA:            pinA,
+		pinB:            pinB,
+		onValue:         PIN_ON,
+		offValue:        PIN_OFF,
+		transitionDelay: time.Millisecond * 5,
+		mutex:           &sync.Mutex{},
+		pinFuncs:        pinFuncs,
+	}
+}
+
+// Pins returns the A and B pins, and the switch pin if the encoder has one
+func (e *RotaryEncoder) Pins() []string {
+	if e.switchAction == nil {
+		return []string{e.pinA, e.pinB}
+	}
+	return []string{e.pinA, e.pinB, e.switchAction.Pin()}
+}
+
+// SetSwitchPin sets the pin of the push switch (SW)
+func (e *RotaryEncoder) SetSwitchPin(pin string) {
+	e.switchAction = NewPinWriteAction(pin, PW_ACTION_BUTTONPRESS, e.pinFuncs)
+}
+
+// SwitchAction returns the button press action of the push switch, or nil if there is none.
+// It can be used to configure the switch, i.e. its on and off values or bounce.
+func (e *RotaryEncoder) SwitchAction() *PinWriteAction {
+	return e.switchAction
+}
+
+// SetOnOffValues sets the values written to the A and B pins for the on and off state.
+// The encoder rests at the off values.
+func (e *RotaryEncoder) SetOnOffValues(on byte, off byte) {
+	e.onValue = on
+	e.offValue = off
+}
+
+// SetTransitionDelay sets the time between the four transitions of a step
+func (e *RotaryEncoder) SetTransitionDelay(d time.Duration) {
+	e.transitionDelay = d
+}
+
+// Position returns the number of steps turned, clockwise being positive
+func (e *RotaryEncoder) Position() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.position
+}
+
+// StepCW turns the encoder one step clockwise
+func (e *RotaryEncoder) StepCW() error {
+	return e.step(context.Background(), 1)
+}
+
+// StepCCW turns the encoder one step counter clockwise
+func (e *RotaryEncoder) StepCCW() error {
+	return e.step(context.Background(), -1)
+}
+
+// Press presses and releases the push switch
+func (e *RotaryEncoder) Press() error {
+	return e.press(context.Background())
+}
+
+// press presses and releases the push switch, unless cancelled
+func (e *RotaryEncoder) press(ctx context.Context) error {
+	if e.switchAction == nil {
+		return errors.New("rotary encoder has no switch pin")
+	}
+	return e.switchAction.ExecuteContext(ctx)
+}
+
+// step writes a full quadrature cycle in the given direction.
+// A cancelled step returns the pins to their rest state
+func (e *RotaryEncoder) step(ctx context.Context, dir int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for i := 1; i <= len(quadrature); i++ {
+		state := quadrature[(i*dir+len(quadrature))%len(quadrature)]
+		if err := e.pinFuncs.Write(e.pinA, e.value(state[0])); err != nil {
+			return err
+		}
+		if err := e.pinFuncs.Write(e.pinB, e.value(state[1])); err != nil {
+			return err
+		}
+		if err := sleep(ctx, e.transitionDelay); err != nil {
+			e.pinFuncs.Write(e.pinA, e.offValue)
+			e.pinFuncs.Write(e.pinB, e.offValue)
+			return err
+		}
+	}
+	e.position += dir
+	return nil
+}
+
+// value translates an on state to the on or off value of the pins
+func (e *RotaryEncoder) value(on bool) byte {
+	if on {
+		return e.onValue
+	}
+	return e.offValue
+}
+
+// CWAction returns an action that turns the encoder one step clockwise
+func (e *RotaryEncoder) CWAction() *RotaryEncoderAction {
+	return &RotaryEncoderAction{encoder: e, name: "encoder cw", execute: func(ctx context.Context) error {
+		return e.step(ctx, 1)
+	}}
+}
+
+// CCWAction returns an action that turns the encoder one step counter clockwise
+func (e *RotaryEncoder) CCWAction() *RotaryEncoderAction {
+	return &RotaryEncoderAction{encoder: e, name: "encoder ccw", execute: func(ctx context.Context) error {
+		return e.step(ctx, -1)
+	}}
+}
+
+// PressAction returns an action that presses the push switch
+func (e *RotaryEncoder) PressAction() *RotaryEncoderAction {
+	return &RotaryEncoderAction{encoder: e, name: "encoder switch", execute: e.press}
+}
+
+// RotaryEncoderAction turns or presses a rotary encoder, so it can be mapped to a key
+type RotaryEncoderAction struct {
+	encoder *RotaryEncoder
+	name    string
+	execute func(ctx context.Context) error
+}
+
+// Encoder returns the encoder the action operates on
+func (ac *RotaryEncoderAction) Encoder() *RotaryEncoder {
+	return ac.encoder
+}
+
+// String describes the action
+func (ac *RotaryEncoderAction) String() string {
+	return ac.name
+}
+
+// Pins returns the pins of the encoder
+func (ac *RotaryEncoderAction) Pins() []string {
+	return ac.encoder.Pins()
+}
+
+// Execute turns or presses the encoder
+func (ac *RotaryEncoderAction) Execute() error {
+	return ac.execute(context.Background())
+}
+
+// ExecuteContext turns or presses the encoder, unless cancelled
+func (ac *RotaryEncoderAction) ExecuteContext(ctx context.Context) error {
+	return ac.execute(ctx)
+}
@@ -0,0 +1,254 @@
+package gobot_sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SequenceStep is a single step in a waveform sequence. The pin is
+// driven to its on or off value and held there for Duration
+type SequenceStep struct {
+	On       bool
+	Duration time.Duration
+}
+
+// PinSequenceAction writes a waveform, a sequence of (value, duration) steps,
+// to a pin. It can be used to simulate double clicks, long presses etc.
+type PinSequenceAction struct {
+	pin   string
+	steps []SequenceStep
+
+	onValue  byte
+	offValue byte
+
+	pinFuncs *PinFuncs
+	bounce   *Bounce
+}
+
+// NewPinSequenceAction creates an action that writes a sequence of steps to a pin
+func NewPinSequenceAction(pin string, steps []SequenceStep, pinFuncs *PinFuncs) *PinSequenceAction {
+	return &PinSequenceAction{
+		pin:      pin,
+		steps:    steps,
+		pinFuncs: pinFuncs,
+		onValue:  PIN_ON,
+		offValue: PIN_OFF,
+	}
+}
+
+// Pin returns the pin number
+func (ac *PinSequenceAction) Pin() string {
+	return ac.pin
+}
+
+// Pins returns the pins the action writes to
+func (ac *PinSequenceAction) Pins() []string {
+	return []string{ac.pin}
+}
+
+// Steps returns the steps of the sequence
+func (ac *PinSequenceAction) Steps() []SequenceStep {
+	return ac.steps
+}
+
+// String describes the action
+func (ac *PinSequenceAction) String() string {
+	return fmt.Sprintf("sequence of %d steps", len(ac.steps))
+}
+
+// SetOnOffValues sets the values written for the on and off state,
+// i.e. PIN_OFF and PIN_ON for an active low pin
+func (ac *PinSequenceAction) SetOnOffValues(on byte, off byte) {
+	ac.onValue = on
+	ac.offValue = off
+}
+
+// SetBounce sets a bounce model that makes the pin chatter whenever
+// a step changes the value. Set to nil for clean edges
+func (ac *PinSequenceAction) SetBounce(bounce *Bounce) {
+	ac.bounce = bounce
+}
+
+// Bounce returns the bounce model of the action, nil if edges are clean
+func (ac *PinSequenceAction) Bounce() *Bounce {
+	return ac.bounce
+}
+
+// Execute writes all steps of the sequence to the pin
+func (ac *PinSequenceAction) Execute() error {
+	return ac.ExecuteContext(context.Background())
+}
+
+// ExecuteContext writes the steps like Execute, but can be cancelled.
+// A cancelled sequence sets the pin to its off value before returning
+func (ac *PinSequenceAction) ExecuteContext(ctx context.Context) error {
+	for i, step := range ac.steps {
+		from, to := ac.onValue, ac.offValue
+		if step.On {
+			from, to = ac.offValue, ac.onValue
+		}
+		held := step.Duration
+		var err error
+		if i == 0 || ac.steps[i-1].On != step.On {
+			err = writeEdge(ctx, ac.pinFuncs, ac.bounce, ac.pin, from, to)
+			held -= bounceDuration(ac.bounce)
+		} else {
+			err = ac.pinFuncs.Write(ac.pin, to)
+		}
+		if err == nil {
+			err = sleep(ctx, held)
+		}
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			ac.pinFuncs.Write(ac.pin, ac.offValue)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PressSequence returns the steps for a button press of the given duration
+func PressSequence(hold time.Duration) []SequenceStep {
+	return []SequenceStep{{On: true, Duration: hold}, {On: false}}
+}
+
+// DoubleClickSequence returns the steps for two short presses, where
+// both the press and the gap between the presses last for d
+func DoubleClickSequence(d time.Duration) []SequenceStep {
+	return []SequenceStep{
+		{On: true, Duration: d},
+		{On: false, Duration: d},
+		{On: true, Duration: d},
+		{On: false},
+	}
+}
+
+// ParseSequencePattern parses a compact pattern string into sequence steps.
+//
+// A pattern is a list of steps separated by spaces or commas. Each step has
+// the form VALUE:DURATION, where VALUE is 1, H or on for the on value and
+// 0, L or off for the off value, and DURATION is a Go duration (i.e. 150ms).
+// The duration may be omitted, which is mostly useful for the last step.
+// A step may be followed by xN to repeat it, and a group of steps enclosed
+// in parentheses may be repeated as a whole.
+//
+// Examples:
+//
+//	"1:2s 0"                      a long press of 2 seconds
+//	"(1:100ms 0:100ms)x2"         a double click
+//	"(1:100ms 0:100ms)x3 1:1s 0"  three short and one long press
+func ParseSequencePattern(pattern string) ([]SequenceStep, error) {
+	tokens := strings.FieldsFunc(pattern, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	})
+	var steps []SequenceStep
+	var group []SequenceStep
+	inGroup := false
+	for _, token := range tokens {
+		startsGroup := strings.HasPrefix(token, "(")
+		if startsGroup {
+			if inGroup {
+				return nil, errors.New("nested groups are not supported in sequence pattern")
+			}
+			inGroup = true
+			token = token[1:]
+		}
+		endsGroup := false
+		repeat := 1
+		if i := strings.LastIndex(token, ")"); i >= 0 {
+			if !inGroup {
+				return nil, fmt.Errorf("unexpected ')' in sequence pattern at '%s'", token)
+			}
+			n, err := parseRepeat(token[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			endsGroup = true
+			repeat = n
+			token = token[:i]
+		}
+
+		var stepRepeat = 1
+		if i := strings.LastIndex(token, "x"); i >= 0 {
+			n, err := parseRepeat(token[i:])
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				return nil, fmt.Errorf("repeat '%s' without a step in sequence pattern", token)
+			}
+			stepRepeat = n
+			token = token[:i]
+		}
+		if token != "" {
+			step, err := parseSequenceStep(token)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < stepRepeat; i++ {
+				if inGroup {
+					group = append(group, step)
+				} else {
+					steps = append(steps, step)
+				}
+			}
+		}
+
+		if endsGroup {
+			if len(group) == 0 {
+				return nil, errors.New("empty group in sequence pattern")
+			}
+			for i := 0; i < repeat; i++ {
+				steps = append(steps, group...)
+			}
+			group = nil
+			inGroup = false
+		}
+	}
+	if inGroup {
+		return nil, errors.New("missing ')' in sequence pattern")
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("sequence pattern contains no steps")
+	}
+	return steps, nil
+}
+
+// parseSequenceStep parses a single VALUE[:DURATION] step
+func parseSequenceStep(token string) (SequenceStep, error) {
+	step := SequenceStep{}
+	parts := strings.SplitN(token, ":", 2)
+	switch strings.ToLower(parts[0]) {
+	case "1", "h", "on":
+		step.On = true
+	case "0", "l", "off":
+		step.On = false
+	default:
+		return step, fmt.Errorf("invalid value '%s' in sequence pattern", parts[0])
+	}
+	if len(parts) == 2 {
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d < 0 {
+			return step, fmt.Errorf("invalid duration '%s' in sequence pattern", parts[1])
+		}
+		step.Duration = d
+	}
+	return step, nil
+}
+
+// parseRepeat parses an optional xN repeat suffix
+func parseRepeat(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "x"))
+	if err != nil || !strings.HasPrefix(s, "x") || n < 1 {
+		return 0, fmt.Errorf("invalid repeat '%s' in sequence pattern", s)
+	}
+	return n, nil
+}
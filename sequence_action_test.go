@@ -0,0 +1,77 @@
+package gobot_sim
+
+import (
+	"strings"
+	"testing"
+)
+
+// formatSteps formats steps like a pattern, without durations of 0
+func formatSteps(steps []SequenceStep) string {
+	var tokens []string
+	for _, step := range steps {
+		token := "0"
+		if step.On {
+			token = "1"
+		}
+		if step.Duration > 0 {
+			token += ":" + step.Duration.String()
+		}
+		tokens = append(tokens, token)
+	}
+	return strings.Join(tokens, " ")
+}
+
+func TestParseSequencePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"1:2s 0", "1:2s 0"},
+		{"H:150ms,L", "1:150ms 0"},
+		{"on:1s\toff:1s", "1:1s 0:1s"},
+		{"1:100msx3", "1:100ms 1:100ms 1:100ms"},
+		{"(1:100ms 0:100ms)x2", "1:100ms 0:100ms 1:100ms 0:100ms"},
+		{"(1:100ms 0:100ms)", "1:100ms 0:100ms"},
+		{"(1:100ms 0:100ms)x3 1:1s 0", "1:100ms 0:100ms 1:100ms 0:100ms 1:100ms 0:100ms 1:1s 0"},
+		{"(1:10ms 0x2)x2", "1:10ms 0 0 1:10ms 0 0"},
+		{"( 1:10ms )", "1:10ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			steps, err := ParseSequencePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatSteps(steps); got != tt.want {
+				t.Errorf("steps %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSequencePatternErrors(t *testing.T) {
+	patterns := []string{
+		"",
+		"  ",
+		"2:100ms",
+		"1:fast",
+		"1:-5ms",
+		"1:100ms x2",
+		"1:100msx0",
+		"1:100msxx",
+		"( )x3",
+		"()",
+		"(1:100ms 0:100ms",
+		"1:100ms)",
+		"((1:100ms))",
+		"(1:100ms)x",
+		"(1:100ms) x2",
+	}
+	for _, pattern := range patterns {
+		t.Run(pattern, func(t *testing.T) {
+			if steps, err := ParseSequencePattern(pattern); err == nil {
+				t.Errorf("parsed %q as %q, want an error", pattern, formatSteps(steps))
+			}
+		})
+	}
+}